@@ -0,0 +1,184 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// commandArgs are the arguments of a command in the config.  They are
+// either a string, which is handed to QtCreator as it is written, or
+// an array of arguments, which are quoted for QtCreator's argument
+// parser:
+//
+//	arguments = "--level 2"
+//	arguments = ["--level", "2", "--name", "has spaces"]
+type commandArgs struct {
+	line   string
+	list   []string
+	isList bool
+}
+
+// UnmarshalTOML accepts a string or an array of strings
+func (args *commandArgs) UnmarshalTOML(value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		*args = commandArgs{line: value}
+		return nil
+
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("arguments must be strings, not %T", item)
+			}
+			list = append(list, s)
+		}
+		*args = commandArgs{list: list, isList: true}
+		return nil
+	}
+
+	return fmt.Errorf("arguments must be a string or an array of strings, not %T", value)
+}
+
+// MarshalTOML writes the arguments back the way they were given
+func (args commandArgs) MarshalTOML() ([]byte, error) {
+	var value interface{} = args.line
+	if args.isList {
+		value = args.list
+	}
+
+	s, err := encodeTomlValue(value)
+	return []byte(s), err
+}
+
+// String is the command line as QtCreator parses it
+func (args commandArgs) String() string {
+	if !args.isList {
+		return args.line
+	}
+
+	quoted := make([]string, len(args.list))
+	for i, arg := range args.list {
+		quoted[i] = quoteArg(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// getList is the arguments one by one.  A string is split the way a
+// unix shell splits it, which is how QtCreator parses it there.
+func (args commandArgs) getList() []string {
+	if args.isList {
+		return args.list
+	}
+
+	return splitArgsUnix(args.line)
+}
+
+// splitArgsUnix splits a command line at unquoted whitespace, removing
+// quotes and backslash escapes like a shell
+func splitArgsUnix(line string) []string {
+	var list []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, ch := range line {
+		switch {
+		case escaped:
+			escaped = false
+			if quote == '"' && !strings.ContainsRune("\"\\$`", ch) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(ch)
+		case ch == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(ch)
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inArg = true
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			if inArg {
+				list = append(list, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(ch)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		list = append(list, arg.String())
+	}
+
+	return list
+}
+
+// quoteArg quotes one argument the way QtCreator's ProcessArgs expects
+// on this host, which is a shell on unix and the msvcrt rules on
+// windows
+func quoteArg(arg string) string {
+	if runtime.GOOS == "windows" {
+		return quoteArgWindows(arg)
+	}
+
+	return quoteArgUnix(arg)
+}
+
+func quoteArgUnix(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+
+	if !strings.ContainsAny(arg, " \t\n\r\"'\\$`<>|;&*?[]#~=%(){}!") {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func quoteArgWindows(arg string) string {
+	if arg == "" {
+		return `""`
+	}
+
+	if !strings.ContainsAny(arg, " \t\"&<>|^%") {
+		return arg
+	}
+
+	// backslashes are only special before a quote, so double any run of
+	// them that ends at a quote or at the closing quote
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for _, ch := range arg {
+		switch ch {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			quoted.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			quoted.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		quoted.WriteRune(ch)
+	}
+	quoted.WriteString(strings.Repeat(`\`, backslashes*2))
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
@@ -0,0 +1,114 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuoteArgUnix(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"--level=2", "'--level=2'"},
+		{"has spaces", "'has spaces'"},
+		{"it's", `'it'\''s'`},
+		{`say "hi"`, `'say "hi"'`},
+		{`back\slash`, `'back\slash'`},
+		{"$HOME", "'$HOME'"},
+		{"*.c", "'*.c'"},
+	}
+
+	for _, test := range tests {
+		got := quoteArgUnix(test.arg)
+		if got != test.want {
+			t.Errorf("quoteArgUnix(%q) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestQuoteArgWindows(t *testing.T) {
+	tests := []struct {
+		arg, want string
+	}{
+		{"", `""`},
+		{"plain", "plain"},
+		{`C:\path\to`, `C:\path\to`},
+		{"has spaces", `"has spaces"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`a\"b`, `"a\\\"b"`},
+		{`a\\"b`, `"a\\\\\"b"`},
+		{`C:\has spaces\`, `"C:\has spaces\\"`},
+		{`back\slash here`, `"back\slash here"`},
+		{"50%", `"50%"`},
+	}
+
+	for _, test := range tests {
+		got := quoteArgWindows(test.arg)
+		if got != test.want {
+			t.Errorf("quoteArgWindows(%q) = %s, want %s", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestSplitArgsUnix(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"--level 2", []string{"--level", "2"}},
+		{" \ta\n b ", []string{"a", "b"}},
+		{"''", []string{""}},
+		{`a "" b`, []string{"a", "", "b"}},
+		{`'has spaces' "and more"`, []string{"has spaces", "and more"}},
+		{`it\'s`, []string{"it's"}},
+		{`'it'\''s'`, []string{"it's"}},
+		{`"say \"hi\""`, []string{`say "hi"`}},
+		{`"a\b"`, []string{`a\b`}},
+		{`'a\b'`, []string{`a\b`}},
+		{`has\ space`, []string{"has space"}},
+		{`pre"fix"ed`, []string{"prefixed"}},
+	}
+
+	for _, test := range tests {
+		got := splitArgsUnix(test.line)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitArgsUnix(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+// arguments quoted for unix split back into the same arguments
+func TestQuoteArgUnixRoundTrip(t *testing.T) {
+	args := []string{
+		"",
+		"plain",
+		"has spaces",
+		"it's",
+		`say "hi"`,
+		`back\slash`,
+		`trailing\`,
+		"$HOME `cmd`",
+		"tab\tand\nnewline",
+		"''",
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArgUnix(arg)
+	}
+
+	line := strings.Join(quoted, " ")
+	got := splitArgsUnix(line)
+	if !reflect.DeepEqual(got, args) {
+		t.Errorf("splitArgsUnix(%q) = %q, want %q", line, got, args)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// a configProblem is one issue found in a config file.  Errors stop a
+// launch; warnings are only reported.
+type configProblem struct {
+	path    string
+	line    int // 0 when the line is not known
+	key     string
+	message string
+	isError bool
+}
+
+func (p configProblem) String() string {
+	severity := "warning"
+	if p.isError {
+		severity = "error"
+	}
+
+	location := p.path
+	if p.line != 0 {
+		location = fmt.Sprintf("%s:%d", p.path, p.line)
+	}
+
+	if p.key == "" {
+		return fmt.Sprintf("%s: %s: %s", location, severity, p.message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s: %s", location, severity, p.key, p.message)
+}
+
+func hasConfigErrors(problems []configProblem) bool {
+	for _, problem := range problems {
+		if problem.isError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keys that are commonly mistyped or were written by older versions of
+// qtcdbg init, and what they should be
+var configKeyHints = map[string]string{
+	"compile_commands.path": "dir",
+	"run.args":              "arguments",
+	"build.args":            "arguments",
+	"run.cwd":               "working_dir",
+	"build.cwd":             "working_dir",
+}
+
+// findKeyLine returns the 1-based line that defines key in the data of
+// the config file at path, or 0 if it can't be found.  The TOML decoder
+// does not expose key positions, so this follows table headers line by
+// line, which covers the way qtcdbg configs are written.
+func findKeyLine(path string, data []byte, key toml.Key) int {
+	if len(key) == 0 {
+		return 0
+	}
+
+	if isJsonConfig(path) {
+		return findJsonKeyLine(data, key)
+	}
+
+	key = getConfigTableKey(path, key)
+	doc := parseTomlDocument(data)
+
+	// a whole unknown table is reported at its header
+	if i := doc.findTable(key.String()); i != -1 {
+		return i + 1
+	}
+
+	table := strings.Join(key[:len(key)-1], ".")
+	return doc.findKey(table, key[len(key)-1]) + 1
+}
+
+// report every key in the file that does not map to TomlConfig
+func getUnknownKeyProblems(path string, data []byte, md toml.MetaData) []configProblem {
+	var problems []configProblem
+
+	undecoded := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		undecoded[key.String()] = true
+	}
+
+	for _, key := range md.Undecoded() {
+		// an unknown table is reported once, not once per key in it
+		if len(key) > 1 && undecoded[key[:len(key)-1].String()] {
+			continue
+		}
+
+		message := "unknown key"
+		if hint, ok := configKeyHints[key.String()]; ok {
+			message = fmt.Sprintf("unknown key, did you mean \"%s\"?", hint)
+		}
+
+		problems = append(problems, configProblem{
+			path:    path,
+			line:    findKeyLine(path, data, key),
+			key:     key.String(),
+			message: message,
+		})
+	}
+
+	return problems
+}
+
+// resolve a config path relative to the project root
+func resolveProjectPath(cfg *TomlConfig, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(cfg.Misc.ProjectRoot, path)
+}
+
+// validateConfig checks the values in cfg before any relative paths in
+// it are resolved.  Paths that a build would create only warn when
+// missing, since they legitimately don't exist before the first build.
+func validateConfig(cfg *TomlConfig) []configProblem {
+	var problems []configProblem
+
+	report := func(isError bool, key, format string, args ...interface{}) {
+		path, line := locateConfigKey(cfg, key)
+		problems = append(problems, configProblem{
+			path:    path,
+			line:    line,
+			key:     key,
+			message: fmt.Sprintf(format, args...),
+			isError: isError,
+		})
+	}
+
+	// stat a path, reporting it if it is missing or the wrong kind
+	checkPath := func(isError bool, key, value string, wantDir bool) {
+		info, err := os.Stat(value)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			report(isError, key, "'%s' does not exist", value)
+		case err != nil:
+			report(isError, key, "%v", err)
+		case wantDir && !info.IsDir():
+			report(true, key, "'%s' is not a directory", value)
+		case !wantDir && info.IsDir():
+			report(true, key, "'%s' is a directory", value)
+		}
+	}
+
+	if cfg.Project.Name == "" {
+		report(true, "project.name", "required field is not set")
+	}
+
+	checkPath(true, "project.relative_root", cfg.Misc.ProjectRoot, true)
+
+	switch cfg.Run.ExecutablePath {
+	case "":
+		// the cmake target's executable is found with the build
+		if cfg.CMake.Target == "" {
+			report(true, "run.executable_path", "required field is not set")
+		}
+	case autoExecutable:
+		// found when the config is loaded
+	default:
+		checkPath(false, "run.executable_path", resolveProjectPath(cfg, cfg.Run.ExecutablePath), false)
+	}
+
+	checkPath(false, "run.working_dir", resolveProjectPath(cfg, cfg.Run.WorkingDir), true)
+
+	for _, run := range cfg.Runs {
+		switch run.ExecutablePath {
+		case "":
+			report(true, "runs.executable_path", "required field is not set in run \"%s\"", run.Name)
+		case autoExecutable:
+			// found when the config is loaded
+		default:
+			checkPath(false, "runs.executable_path", resolveProjectPath(cfg, run.ExecutablePath), false)
+		}
+		checkPath(false, "runs.working_dir", resolveProjectPath(cfg, run.WorkingDir), true)
+	}
+
+	if cfg.Build.Command != "" {
+		checkPath(false, "build.working_dir", resolveProjectPath(cfg, cfg.Build.WorkingDir), true)
+	}
+
+	if cfg.CMake.BuildDir != "" {
+		checkPath(false, "cmake.build_dir", resolveProjectPath(cfg, cfg.CMake.BuildDir), true)
+	}
+
+	if cfg.Meson.BuildDir != "" {
+		checkPath(false, "meson.build_dir", resolveProjectPath(cfg, cfg.Meson.BuildDir), true)
+	}
+
+	if cfg.CMake.Target != "" && cfg.CMake.BuildDir == "" {
+		report(true, "cmake.target", "needs cmake.build_dir to find the target in")
+	}
+
+	for _, includeDir := range cfg.Generate.AdditionalIncludeSearchDirs {
+		checkPath(false, "generate.additional_include_search_dirs", resolveProjectPath(cfg, includeDir), true)
+	}
+
+	if cfg.CompileCommands.Override {
+		if cfg.CompileCommands.Dir == "" {
+			report(true, "compile_commands.dir", "required when override is set")
+		} else {
+			compileCommandsPath := filepath.Join(resolveProjectPath(cfg, cfg.CompileCommands.Dir), "compile_commands.json")
+			checkPath(true, "compile_commands.dir", compileCommandsPath, false)
+		}
+	}
+
+	return problems
+}
+
+func printConfigProblems(problems []configProblem) {
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+}
+
+// Check parses and validates the config without generating anything,
+// returning non-zero if there are errors.
+func Check() int {
+	actualConfigPath, err := findConfig(*checkConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		return 1
+	}
+
+	cfg, err := parseConfig(actualConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	printConfigProblems(cfg.Misc.Problems)
+
+	errorCount := 0
+	for _, problem := range cfg.Misc.Problems {
+		if problem.isError {
+			errorCount++
+		}
+	}
+	fmt.Printf("%s: %d errors, %d warnings\n", actualConfigPath,
+		errorCount, len(cfg.Misc.Problems)-errorCount)
+
+	if errorCount != 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,314 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// qtcdbg asks the CMake File API for the codemodel with a query file
+// of its own in the build dir.  CMake answers it the next time the
+// build dir is configured.
+const cmakeQueryClient = "client-qtcdbg"
+
+// a cmakeProject is what the CMake File API reply says about a build
+type cmakeProject struct {
+	executables []cmakeExecutable
+	includes    []string // absolute include directories
+	defines     []string // like config_defines: "NAME VALUE"
+	cflags      []string // compile command fragments, one per line
+	cxxflags    []string
+}
+
+type cmakeExecutable struct {
+	name string
+	path string // absolute path of the built executable
+}
+
+// the parts of the reply files qtcdbg reads.  See cmake-file-api(7).
+type cmakeReplyIndex struct {
+	Reply map[string]map[string]struct {
+		JsonFile string `json:"jsonFile"`
+		Error    string `json:"error"`
+	} `json:"reply"`
+}
+
+type cmakeCodemodel struct {
+	Paths struct {
+		Source string `json:"source"`
+		Build  string `json:"build"`
+	} `json:"paths"`
+	Configurations []struct {
+		Name    string `json:"name"`
+		Targets []struct {
+			Name     string `json:"name"`
+			JsonFile string `json:"jsonFile"`
+		} `json:"targets"`
+	} `json:"configurations"`
+}
+
+type cmakeTarget struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Artifacts []struct {
+		Path string `json:"path"`
+	} `json:"artifacts"`
+	CompileGroups []struct {
+		Language                string `json:"language"`
+		CompileCommandFragments []struct {
+			Fragment string `json:"fragment"`
+		} `json:"compileCommandFragments"`
+		Includes []struct {
+			Path string `json:"path"`
+		} `json:"includes"`
+		Defines []struct {
+			Define string `json:"define"`
+		} `json:"defines"`
+	} `json:"compileGroups"`
+}
+
+func getCMakeApiDir(buildDir string) string {
+	return filepath.Join(buildDir, ".cmake", "api", "v1")
+}
+
+func getCMakeQueryPath(buildDir string) string {
+	return filepath.Join(getCMakeApiDir(buildDir), "query", cmakeQueryClient, "codemodel-v2")
+}
+
+// writeCMakeQuery asks for the codemodel in buildDir, if it hasn't
+// been asked for already.  Only the commands that set up or use the
+// project call it, so check and export leave the build tree alone.  A
+// missing build dir is not created.
+func writeCMakeQuery(buildDir string) error {
+	if info, err := os.Stat(buildDir); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	queryPath := getCMakeQueryPath(buildDir)
+	if isFile(queryPath) {
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(queryPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(queryPath, nil, 0644)
+}
+
+func readCMakeReplyFile(replyDir, name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(replyDir, name))
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	return nil
+}
+
+// errNoCMakeReply is returned when the build dir has not been
+// configured since the query was written
+var errNoCMakeReply = errors.New("no CMake File API reply")
+
+// readCMakeReply reads the codemodel from the newest reply in buildDir
+func readCMakeReply(buildDir string) (*cmakeProject, error) {
+	replyDir := filepath.Join(getCMakeApiDir(buildDir), "reply")
+
+	// index files are named by time, so the last is the newest
+	indexes, _ := filepath.Glob(filepath.Join(replyDir, "index-*.json"))
+	if len(indexes) == 0 {
+		return nil, errNoCMakeReply
+	}
+	sort.Strings(indexes)
+
+	var index cmakeReplyIndex
+	err := readCMakeReplyFile(replyDir, filepath.Base(indexes[len(indexes)-1]), &index)
+	if err != nil {
+		return nil, err
+	}
+
+	codemodelFile, ok := index.Reply[cmakeQueryClient]["codemodel-v2"]
+	switch {
+	case !ok:
+		return nil, errNoCMakeReply
+	case codemodelFile.Error != "":
+		return nil, fmt.Errorf("CMake could not answer the codemodel query: %s", codemodelFile.Error)
+	}
+
+	var codemodel cmakeCodemodel
+	err = readCMakeReplyFile(replyDir, codemodelFile.JsonFile, &codemodel)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(codemodel.Configurations) == 0 {
+		return nil, fmt.Errorf("%s: no configurations", codemodelFile.JsonFile)
+	}
+
+	// multi-config generators have one configuration per build type
+	configuration := codemodel.Configurations[0]
+	for _, candidate := range codemodel.Configurations {
+		if candidate.Name == "Debug" {
+			configuration = candidate
+		}
+	}
+
+	// relative paths in the reply are relative to the top of the build
+	// or source tree
+	resolve := func(top, path string) string {
+		if filepath.IsAbs(path) {
+			return filepath.Clean(path)
+		}
+		return filepath.Join(top, path)
+	}
+
+	project := &cmakeProject{}
+	seen := make(map[*[]string]map[string]bool)
+	add := func(list *[]string, value string) {
+		if seen[list] == nil {
+			seen[list] = make(map[string]bool)
+		}
+
+		if !seen[list][value] {
+			seen[list][value] = true
+			*list = append(*list, value)
+		}
+	}
+
+	for _, targetRef := range configuration.Targets {
+		var target cmakeTarget
+		err = readCMakeReplyFile(replyDir, targetRef.JsonFile, &target)
+		if err != nil {
+			return nil, err
+		}
+
+		if target.Type == "EXECUTABLE" && len(target.Artifacts) != 0 {
+			project.executables = append(project.executables, cmakeExecutable{
+				name: target.Name,
+				path: resolve(codemodel.Paths.Build, target.Artifacts[0].Path),
+			})
+		}
+
+		for _, group := range target.CompileGroups {
+			for _, include := range group.Includes {
+				add(&project.includes, resolve(codemodel.Paths.Source, include.Path))
+			}
+
+			for _, define := range group.Defines {
+				name, value, _ := strings.Cut(define.Define, "=")
+				add(&project.defines, strings.TrimSpace(name+" "+value))
+			}
+
+			flags := &project.cflags
+			if group.Language == "CXX" {
+				flags = &project.cxxflags
+			} else if group.Language != "C" {
+				continue
+			}
+
+			// a fragment is kept whole, since one flag's argument can
+			// repeat in another, like the headers of two -include flags
+			for _, fragment := range group.CompileCommandFragments {
+				add(flags, strings.TrimSpace(fragment.Fragment))
+			}
+		}
+	}
+
+	return project, nil
+}
+
+// applyCMakeDefaults uses [cmake] build_dir for compile_commands.dir
+// when it is not set and CMake exports a compile database there, which
+// it does with CMAKE_EXPORT_COMPILE_COMMANDS
+func applyCMakeDefaults(cfg *TomlConfig) {
+	buildDir := resolveProjectPath(cfg, cfg.CMake.BuildDir)
+
+	if cfg.CompileCommands.Dir == "" && isFile(filepath.Join(buildDir, "compile_commands.json")) {
+		cfg.CompileCommands.Dir = buildDir
+	}
+}
+
+// loadCMakeProject reads the CMake File API reply for [cmake] build_dir
+// into cfg.  The [cmake] target, if set, is debugged by [run], and the
+// other executable targets are added as run configs.  A missing reply
+// is only a warning that says how to get one.
+func loadCMakeProject(cfg *TomlConfig) []configProblem {
+	report := func(isError bool, key, format string, args ...interface{}) []configProblem {
+		path, line := locateConfigKey(cfg, key)
+		return []configProblem{{
+			path:    path,
+			line:    line,
+			key:     key,
+			message: fmt.Sprintf(format, args...),
+			isError: isError,
+		}}
+	}
+
+	// a missing build dir is reported by validateConfig
+	buildDir := resolveProjectPath(cfg, cfg.CMake.BuildDir)
+	if info, err := os.Stat(buildDir); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	project, err := readCMakeReply(buildDir)
+	if errors.Is(err, errNoCMakeReply) && !isFile(getCMakeQueryPath(buildDir)) {
+		return report(false, "cmake.build_dir", "no CMake File API reply yet; run \"qtcdbg generate\" to ask for one, then \"cmake %s\" to create it", buildDir)
+	}
+	if errors.Is(err, errNoCMakeReply) {
+		return report(false, "cmake.build_dir", "no CMake File API reply yet; run \"cmake %s\" to configure it again and create one", buildDir)
+	}
+	if err != nil {
+		return report(false, "cmake.build_dir", "%v", err)
+	}
+
+	cfg.Misc.CMake = project
+
+	var problems []configProblem
+	if cfg.CMake.Target != "" {
+		found := false
+		for _, exe := range project.executables {
+			if exe.name == cfg.CMake.Target {
+				cfg.Run.ExecutablePath = exe.path
+				found = true
+			}
+		}
+
+		if !found {
+			problems = report(true, "cmake.target", "\"%s\" is not an executable target of the build in %s", cfg.CMake.Target, cfg.CMake.BuildDir)
+		}
+	}
+
+	// the other executables can be run too, from where they are built
+	debugged := map[string]bool{resolveProjectPath(cfg, cfg.Run.ExecutablePath): true}
+	for _, run := range cfg.Runs {
+		debugged[resolveProjectPath(cfg, run.ExecutablePath)] = true
+	}
+
+	for _, exe := range project.executables {
+		if debugged[exe.path] {
+			continue
+		}
+
+		cfg.Runs = append(cfg.Runs, runConfig{
+			Name:           exe.name,
+			WorkingDir:     filepath.Dir(exe.path),
+			ExecutablePath: exe.path,
+			RunInTerminal:  cfg.Run.RunInTerminal,
+		})
+	}
+
+	return problems
+}
@@ -0,0 +1,69 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// two -include fragments stay two flags with their own headers
+func TestReadCMakeReplyFragments(t *testing.T) {
+	buildDir := t.TempDir()
+	replyDir := filepath.Join(getCMakeApiDir(buildDir), "reply")
+
+	writeTestFile(t, replyDir, "index-2024-01-01T00-00-00-0000.json", `{
+	"reply": {
+		"client-qtcdbg": {
+			"codemodel-v2": {"jsonFile": "codemodel-v2.json"}
+		}
+	}
+}`)
+	writeTestFile(t, replyDir, "codemodel-v2.json", `{
+	"paths": {"source": "/src", "build": "/src/build"},
+	"configurations": [{
+		"name": "Debug",
+		"targets": [
+			{"name": "a", "jsonFile": "target-a.json"},
+			{"name": "b", "jsonFile": "target-b.json"}
+		]
+	}]
+}`)
+	writeTestFile(t, replyDir, "target-a.json", `{
+	"name": "a",
+	"type": "EXECUTABLE",
+	"artifacts": [{"path": "a"}],
+	"compileGroups": [{
+		"language": "CXX",
+		"compileCommandFragments": [
+			{"fragment": "-g"},
+			{"fragment": "-include a.h"}
+		]
+	}]
+}`)
+	writeTestFile(t, replyDir, "target-b.json", `{
+	"name": "b",
+	"type": "EXECUTABLE",
+	"artifacts": [{"path": "b"}],
+	"compileGroups": [{
+		"language": "CXX",
+		"compileCommandFragments": [
+			{"fragment": "-g"},
+			{"fragment": "-include b.h"}
+		]
+	}]
+}`)
+
+	project, err := readCMakeReply(buildDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"-g", "-include a.h", "-include b.h"}
+	if !reflect.DeepEqual(project.cxxflags, want) {
+		t.Errorf("cxxflags are %q, want %q", project.cxxflags, want)
+	}
+}
@@ -33,9 +33,8 @@ func SetClangdPath(clangdPath string) error {
 	}
 
 	cfg.Section("ClangdSettings").Key("ClangdPath").SetValue(clangdPath)
-	cfg.SaveTo(iniPath)
 
-	return nil
+	return cfg.SaveTo(iniPath)
 }
 
 func GetClangdPath() (string, error) {
@@ -5,75 +5,564 @@
 package main
 
 import (
-	"io/ioutil"
-	"log"
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 // "github.com/BurntSushi/toml"
 type TomlConfig struct {
+	SchemaVersion int `toml:"schema_version"`
+
+	// file this one is layered over, and the dotted keys of arrays to
+	// append to the extended file's arrays rather than replace them
+	Extends string   `toml:"extends,omitempty"`
+	Append  []string `toml:"append,omitempty"`
+
 	Project struct {
 		Name         string `toml:"name"`
 		RelativeRoot string `toml:"relative_root"`
 	} `toml:"project"`
 	Build struct {
-		WorkingDir string `toml:"working_dir"`
-		Command    string `toml:"command"`
-		Arguments  string `toml:"arguments"`
+		WorkingDir string      `toml:"working_dir"`
+		Command    string      `toml:"command"`
+		Arguments  commandArgs `toml:"arguments"`
 	} `toml:"build"`
-	Run struct {
-		WorkingDir     string `toml:"working_dir"`
-		ExecutablePath string `toml:"executable_path"`
-		Arguments      string `toml:"arguments"`
-		RunInTerminal  bool   `toml:"run_in_terminal"`
-	} `toml:"run"`
+	Run runConfig `toml:"run"`
+
+	// more ways to run the program, each a run configuration in QtCreator
+	Runs []runConfig `toml:"runs,omitempty"`
+
 	Generate struct {
 		ConfigDefines               []string `toml:"config_defines"`
 		ConfigCFlags                []string `toml:"config_cflags"`
 		AdditionalIncludeSearchDirs []string `toml:"additional_include_search_dirs"`
+		OutputDir                   string   `toml:"output_dir"`
+		FollowSymlinks              bool     `toml:"follow_symlinks"`
+		SkipDirs                    []string `toml:"skip_dirs"`
+		SkipSubmodules              bool     `toml:"skip_submodules"`
 	} `toml:"generate"`
 	CompileCommands struct {
 		Override bool   `toml:"override"`
 		Dir      string `toml:"dir"`
 	} `toml:"compile_commands"`
 
+	// a CMake build dir whose File API reply supplies run configs,
+	// include dirs, defines and flags, and the executable target to
+	// debug
+	CMake struct {
+		BuildDir string `toml:"build_dir"`
+		Target   string `toml:"target"`
+	} `toml:"cmake"`
+
+	// a configured meson build dir to introspect for run configs, and
+	// to build and take compile_commands.json from when those aren't
+	// set
+	Meson struct {
+		BuildDir string `toml:"build_dir"`
+	} `toml:"meson"`
+
+	// user defined variables, used in other values as ${name}
+	Vars map[string]string `toml:"vars"`
+
 	// not in toml parse
 	Misc struct {
 		cfgPath            string
 		EnvironmentId      string
 		KitId              string
 		ProjectRoot        string
+		OutputDir          string
+		Scan               *projectScan
+		Problems           []configProblem
+		Layers             []*configLayer
+		Sources            map[string]string // dotted key to the file that set it
+		RunConfigs         []runConfig       // [run], then [[runs]]
+		CMake              *cmakeProject     // nil without a File API reply
 		OriginalClangdPath string
+	} `toml:"-"`
+}
+
+// a runConfig is a way to run the program under the debugger.  [run]
+// is the default one and [[runs]] add more, with a name to tell them
+// apart in QtCreator.
+type runConfig struct {
+	Name           string      `toml:"name,omitempty"`
+	WorkingDir     string      `toml:"working_dir"`
+	ExecutablePath string      `toml:"executable_path"`
+	Arguments      commandArgs `toml:"arguments"`
+	RunInTerminal  bool        `toml:"run_in_terminal"`
+
+	// variables set in the debugged program's environment
+	Environment map[string]string `toml:"environment,omitempty"`
+
+	// debugger commands run before the program starts
+	DebuggerStartup []string `toml:"debugger_startup,omitempty"`
+
+	// the environment as written, before variables are expanded
+	rawEnvironment map[string]string
+}
+
+// a configLayer is one file that contributes to the config.  Layers
+// are merged in order, later ones overriding earlier ones.
+type configLayer struct {
+	path    string
+	data    []byte // after migration, so line numbers match the file
+	raw     map[string]interface{}
+	version int // schema version before migration
+}
+
+// the optional per-user override of a config file, which sits next to
+// it and should not be checked in: qtcdbg.linux.toml is overridden by
+// qtcdbg.linux.local.toml
+func getLocalConfigPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".local" + ext
+}
+
+// decode a TOML error into one that points at the line in path
+func formatDecodeError(path string, err error) error {
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%s:%d: %v", path, parseErr.Position.Line, parseErr)
 	}
+
+	return fmt.Errorf("%s: %v", path, err)
 }
 
-func parseConfig(path string) (TomlConfig, error) {
-	var cfg TomlConfig
+// readConfigLayer reads and migrates one TOML or JSON config file.  It is decoded
+// into a TomlConfig of its own so that type errors and unknown keys are
+// reported against the file they are in.  An override without a
+// schema_version is read at the version of the layer it overrides,
+// which is nil for the main config.
+func readConfigLayer(path string, overrides *configLayer) (*configLayer, []configProblem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var problems []configProblem
+
+	raw, err := decodeConfigData(path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// older configs are upgraded in memory before decoding
+	version, isSet, err := getSchemaVersion(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s:%d: %v", path, findKeyLine(path, data, toml.Key{"schema_version"}), err)
+	}
+	if !isSet && overrides != nil {
+		version = overrides.version
+	}
+
+	var doc configDocument
+	if isJsonConfig(path) {
+		doc = &jsonDocument{data: data, raw: raw}
+	} else {
+		doc = parseTomlConfigDocument(path, data)
+	}
+
+	// an override's relative paths are rebased against the project root
+	// of the file it overrides
+	inheritedRoot := ""
+	if overrides != nil {
+		value, _ := getTableValue(overrides.raw, "project.relative_root")
+		inheritedRoot, _ = value.(string)
+	}
+
+	deprecations, err := migrateConfig(doc, path, version, inheritedRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, deprecation := range deprecations {
+		// the layer this one overrides already warned about its version
+		if deprecation.key == "schema_version" && !isSet && overrides != nil {
+			continue
+		}
+
+		deprecation.message += "; run \"qtcdbg migrate\" to update the file"
+		problems = append(problems, deprecation)
+	}
+
+	// a TOML file is decoded again from its migrated text.  A JSON file
+	// was migrated in its map, and keeps the original text for finding
+	// lines.
+	if doc, ok := doc.(*tomlDocument); ok {
+		data = doc.Bytes()
+		raw, err = decodeConfigData(path, data)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	layer := &configLayer{path: path, data: data, raw: raw, version: version}
 
-	file, err := os.Open(path)
+	// JSON is decoded through TOML, so both formats are checked against
+	// TomlConfig the same way.  So is the [tool.qtcdbg] table alone of a
+	// pyproject.toml, leaving the rest of the file out of the check.
+	layerToml := string(data)
+	if isJsonConfig(path) || isPyprojectConfig(path) {
+		var encoded bytes.Buffer
+		err = toml.NewEncoder(&encoded).Encode(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		layerToml = encoded.String()
+	}
+
+	var layerCfg TomlConfig
+	md, err := toml.Decode(layerToml, &layerCfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, formatLayerDecodeError(layer, err)
 	}
+	problems = append(problems, getUnknownKeyProblems(path, data, md)...)
 
-	tomlBytes, err := ioutil.ReadAll(file)
+	return layer, problems, nil
+}
+
+// keys that apply to the file they are in, rather than the merged config
+var layerOnlyKeys = map[string]bool{
+	"extends": true,
+	"append":  true,
+}
+
+// mergeConfigTable deep merges src over dst.  Tables merge key by key;
+// any other value replaces what dst had, except that arrays named in
+// appendKeys are appended to.  The file each value came from is
+// recorded in sources by dotted key.
+func mergeConfigTable(dst, src map[string]interface{}, prefix, path string, appendKeys map[string]bool, sources map[string]string) {
+	for name, value := range src {
+		key := prefix + name
+		if prefix == "" && layerOnlyKeys[name] {
+			continue
+		}
+
+		if srcTable, ok := value.(map[string]interface{}); ok {
+			dstTable, ok := dst[name].(map[string]interface{})
+			if !ok {
+				dstTable = make(map[string]interface{})
+				dst[name] = dstTable
+			}
+
+			mergeConfigTable(dstTable, srcTable, key+".", path, appendKeys, sources)
+			continue
+		}
+
+		if appendKeys[key] {
+			switch dstArray := dst[name].(type) {
+			case []interface{}:
+				if srcArray, ok := value.([]interface{}); ok {
+					value = append(dstArray[:len(dstArray):len(dstArray)], srcArray...)
+				}
+			case []map[string]interface{}:
+				if srcArray, ok := value.([]map[string]interface{}); ok {
+					value = append(dstArray[:len(dstArray):len(dstArray)], srcArray...)
+				}
+			}
+		}
+
+		// copied, so expanding variables in the merged config leaves
+		// the layer as written
+		dst[name] = copyConfigValue(value)
+		sources[key] = path
+	}
+}
+
+// copyConfigValue deep copies the arrays and tables of a raw value
+func copyConfigValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		table := make(map[string]interface{}, len(value))
+		for name, item := range value {
+			table[name] = copyConfigValue(item)
+		}
+		return table
+
+	case []interface{}:
+		array := make([]interface{}, len(value))
+		for i, item := range value {
+			array[i] = copyConfigValue(item)
+		}
+		return array
+
+	case []map[string]interface{}:
+		tables := make([]map[string]interface{}, len(value))
+		for i, item := range value {
+			tables[i] = copyConfigValue(item).(map[string]interface{})
+		}
+		return tables
+	}
+
+	return value
+}
+
+// getTableValue finds the value at a dotted key in a raw table
+func getTableValue(table map[string]interface{}, key string) (interface{}, bool) {
+	var value interface{} = table
+	for _, name := range strings.Split(key, ".") {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		value, ok = table[name]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// merge one layer over the config merged so far
+func mergeConfigLayer(merged map[string]interface{}, layer *configLayer, sources map[string]string) {
+	appendKeys := make(map[string]bool)
+	if appendList, ok := layer.raw["append"].([]interface{}); ok {
+		for _, key := range appendList {
+			if key, ok := key.(string); ok {
+				appendKeys[key] = true
+			}
+		}
+	}
+
+	mergeConfigTable(merged, layer.raw, "", layer.path, appendKeys, sources)
+}
+
+// readConfigChain reads the layers for the config file at path: the
+// files it extends, base first, then the file itself.  chain holds the
+// files that are already extending it, to catch cycles.  overrides is
+// the layer the first file read overrides, if any.
+func readConfigChain(path string, overrides *configLayer, chain []string) ([]*configLayer, []configProblem, error) {
+	absPath, err := filepath.Abs(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	if err := toml.Unmarshal(tomlBytes, &cfg); err != nil {
-		log.Fatal(err)
+	for i, chained := range chain {
+		if chained == absPath {
+			cycle := append(chain[i:len(chain):len(chain)], absPath)
+			return nil, nil, fmt.Errorf("extends cycle: %s", strings.Join(cycle, " -> "))
+		}
 	}
+	chain = append(chain[:len(chain):len(chain)], absPath)
 
+	// the extended file is layered below this one, so it is read first
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := decodeConfigData(path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var layers []*configLayer
+	var problems []configProblem
+
+	if extends, ok := raw["extends"].(string); ok && extends != "" {
+		basePath := extends
+		if !filepath.IsAbs(basePath) {
+			basePath = filepath.Join(filepath.Dir(path), basePath)
+		}
+
+		if _, err := os.Stat(basePath); err != nil {
+			return nil, nil, fmt.Errorf("%s: could not read extended config: %v", path, err)
+		}
+
+		layers, problems, err = readConfigChain(basePath, overrides, chain)
+		if err != nil {
+			return nil, nil, err
+		}
+		overrides = layers[len(layers)-1]
+	}
+
+	layer, layerProblems, err := readConfigLayer(path, overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *debug {
+		fmt.Printf("Loading config layer %s\n", path)
+	}
+
+	return append(layers, layer), append(problems, layerProblems...), nil
+}
+
+// loadConfigLayers merges the config file at path, the files it
+// extends and its local override, if there is one, then expands
+// variables and decodes the result.  Relative paths are left as they
+// are in the files.
+func loadConfigLayers(path string) (TomlConfig, error) {
+	var cfg TomlConfig
+
+	merged := make(map[string]interface{})
 	cfg.Misc.cfgPath = path
+	cfg.Misc.Sources = make(map[string]string)
+
+	layers, problems, err := readConfigChain(path, nil, nil)
+	if err != nil {
+		return cfg, err
+	}
+
+	localPath := getLocalConfigPath(path)
+	if _, err := os.Stat(localPath); err == nil {
+		localLayers, localProblems, err := readConfigChain(localPath, layers[len(layers)-1], nil)
+		if err != nil {
+			return cfg, err
+		}
+
+		layers = append(layers, localLayers...)
+		problems = append(problems, localProblems...)
+	}
+
+	cfg.Misc.Layers = layers
+	cfg.Misc.Problems = problems
+	for _, layer := range layers {
+		mergeConfigLayer(merged, layer, cfg.Misc.Sources)
+	}
+
+	rawEnvironment, rawRunsEnvironments := getRawEnvironments(merged)
+	cfg.Misc.Problems = append(cfg.Misc.Problems, interpolateConfig(&cfg, merged)...)
+
+	var mergedToml bytes.Buffer
+	err = toml.NewEncoder(&mergedToml).Encode(merged)
+	if err != nil {
+		return cfg, err
+	}
+
+	// keep what was gathered while loading the layers, decoding only
+	// fills in the toml fields
+	misc := cfg.Misc
+	_, err = toml.Decode(mergedToml.String(), &cfg)
+	if err != nil {
+		return cfg, formatDecodeError(path, err)
+	}
+	cfg.Misc = misc
+
+	cfg.Run.rawEnvironment = rawEnvironment
+	for i := range cfg.Runs {
+		if i < len(rawRunsEnvironments) {
+			cfg.Runs[i].rawEnvironment = rawRunsEnvironments[i]
+		}
+	}
+
+	return cfg, nil
+}
+
+// getRawEnvironments returns the environments of [run] and of each
+// entry in [[runs]] in a merged config, before variables are expanded
+func getRawEnvironments(merged map[string]interface{}) (map[string]string, []map[string]string) {
+	getEnvironment := func(table map[string]interface{}) map[string]string {
+		environment, _ := table["environment"].(map[string]interface{})
+
+		raw := make(map[string]string)
+		for name, value := range environment {
+			if value, ok := value.(string); ok {
+				raw[name] = value
+			}
+		}
+
+		return raw
+	}
+
+	runTable, _ := merged["run"].(map[string]interface{})
+
+	// json configs decode arrays of tables as arrays of anything
+	var runsEnvironments []map[string]string
+	switch runs := merged["runs"].(type) {
+	case []map[string]interface{}:
+		for _, table := range runs {
+			runsEnvironments = append(runsEnvironments, getEnvironment(table))
+		}
+	case []interface{}:
+		for _, item := range runs {
+			table, _ := item.(map[string]interface{})
+			runsEnvironments = append(runsEnvironments, getEnvironment(table))
+		}
+	}
+
+	return getEnvironment(runTable), runsEnvironments
+}
+
+// locate the file and line that set a dotted key.  A key no file sets
+// is attributed to the main config file.
+func locateConfigKey(cfg *TomlConfig, key string) (string, int) {
+	source, ok := cfg.Misc.Sources[key]
+	if !ok {
+		return cfg.Misc.cfgPath, 0
+	}
+
+	for _, layer := range cfg.Misc.Layers {
+		if layer.path == source {
+			return source, findKeyLine(layer.path, layer.data, strings.Split(key, "."))
+		}
+	}
+
+	return source, 0
+}
+
+// parseConfig loads the config file at path, layered with its local
+// override, and resolves its relative paths.  Syntax errors are
+// returned with the line they occur on.  Unknown keys and invalid
+// values are collected in cfg.Misc.Problems for the caller to report.
+func parseConfig(path string) (TomlConfig, error) {
+	cfg, err := loadConfigLayers(path)
+	if err != nil {
+		return cfg, err
+	}
+
 	cfg.Misc.ProjectRoot = getProjectRoot(&cfg)
 
+	// build system defaults fill in fields validateConfig requires
+	if cfg.CMake.BuildDir != "" {
+		applyCMakeDefaults(&cfg)
+	}
+
+	if cfg.Meson.BuildDir != "" {
+		applyMesonDefaults(&cfg)
+	}
+
+	cfg.Misc.Problems = append(cfg.Misc.Problems, validateConfig(&cfg)...)
+
+	if cfg.CMake.BuildDir != "" {
+		cfg.Misc.Problems = append(cfg.Misc.Problems, loadCMakeProject(&cfg)...)
+	}
+
+	if cfg.Meson.BuildDir != "" {
+		cfg.Misc.Problems = append(cfg.Misc.Problems, loadMesonProject(&cfg)...)
+	}
+
+	if cfg.Run.ExecutablePath == autoExecutable {
+		cfg.Misc.Problems = append(cfg.Misc.Problems, resolveAutoExecutable(&cfg, &cfg.Run, "run.executable_path")...)
+	}
+
+	for i := range cfg.Runs {
+		if cfg.Runs[i].ExecutablePath == autoExecutable {
+			cfg.Misc.Problems = append(cfg.Misc.Problems, resolveAutoExecutable(&cfg, &cfg.Runs[i], "runs.executable_path")...)
+		}
+	}
+
 	// handle relative paths
-	cfg.Run.ExecutablePath = filepath.Join(cfg.Misc.ProjectRoot, cfg.Run.ExecutablePath)
+	cfg.Misc.RunConfigs = append([]runConfig{cfg.Run}, cfg.Runs...)
+	for i := range cfg.Misc.RunConfigs {
+		run := &cfg.Misc.RunConfigs[i]
+		run.ExecutablePath = resolveProjectPath(&cfg, run.ExecutablePath)
+		run.WorkingDir = resolveProjectPath(&cfg, run.WorkingDir)
+	}
+	cfg.Run = cfg.Misc.RunConfigs[0]
 
-	cfg.Run.WorkingDir = filepath.Join(cfg.Misc.ProjectRoot, cfg.Run.WorkingDir)
+	// clangd is started from wherever qtcreator is, not the project root
+	if cfg.CompileCommands.Dir != "" {
+		cfg.CompileCommands.Dir = resolveProjectPath(&cfg, cfg.CompileCommands.Dir)
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,96 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ShowConfig prints the config that results from merging the config
+// file with its local override, noting which file set each value.
+// Values no file sets are marked as defaults.  Problems loading it are
+// reported after it, as check reports them.
+func ShowConfig() int {
+	actualConfigPath, err := findConfig(*configShowPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		return 1
+	}
+
+	cfg, err := loadConfigLayers(actualConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var effective bytes.Buffer
+	encoder := toml.NewEncoder(&effective)
+	encoder.Indent = ""
+	err = encoder.Encode(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	doc := parseTomlDocument(effective.Bytes())
+
+	// line the source comments up, within reason
+	commentColumn := 0
+	for _, line := range doc.lines {
+		if _, ok := getLineKey(line); ok && len(line) > commentColumn {
+			commentColumn = len(line)
+		}
+	}
+	if commentColumn > 56 {
+		commentColumn = 56
+	}
+
+	table := ""
+	for _, line := range doc.lines {
+		if lineTable, ok := getLineTable(line); ok {
+			table = lineTable
+			fmt.Println(line)
+			continue
+		}
+
+		name, ok := getLineKey(line)
+		if !ok {
+			fmt.Println(line)
+			continue
+		}
+
+		key := name
+		if table != "" {
+			key = table + "." + name
+		}
+
+		// keys in arrays of tables are set along with the whole array
+		source, ok := cfg.Misc.Sources[key]
+		for parent := key; !ok && strings.Contains(parent, "."); {
+			parent = parent[:strings.LastIndex(parent, ".")]
+			source, ok = cfg.Misc.Sources[parent]
+		}
+		if !ok {
+			source = "default"
+		}
+
+		padding := strings.Repeat(" ", max(commentColumn-len(line), 0))
+		fmt.Printf("%s%s  # %s\n", line, padding, source)
+	}
+
+	// problems loading it, like undefined variables, mean the config
+	// shown is not the one qtcdbg would use
+	printConfigProblems(cfg.Misc.Problems)
+	if hasConfigErrors(cfg.Misc.Problems) {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,166 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeTestFile writes data to name under dir, making any directories
+func writeTestFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(data), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// [meson] build_dir supplies compile_commands.dir before the config is
+// validated, so override doesn't need it set
+func TestParseConfigMesonCompileCommandsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "builddir/compile_commands.json", "[]")
+	path := writeTestFile(t, dir, "qtcdbg.toml", `schema_version = 2
+
+[project]
+name = "test"
+relative_root = "."
+
+[run]
+working_dir = "."
+executable_path = "builddir/test"
+
+[meson]
+build_dir = "builddir"
+
+[compile_commands]
+override = true
+`)
+
+	cfg, err := parseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, problem := range cfg.Misc.Problems {
+		if problem.isError {
+			t.Errorf("unexpected error: %v", problem)
+		}
+	}
+
+	want := filepath.Join(dir, "builddir")
+	if cfg.CompileCommands.Dir != want {
+		t.Errorf("compile_commands.dir is %q, want %q", cfg.CompileCommands.Dir, want)
+	}
+}
+
+// a pyproject.toml is read from its [tool.qtcdbg] table, with lines
+// reported against the whole file
+func TestParseConfigPyproject(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "pyproject.toml", `[project]
+name = "python-name"
+
+[tool.qtcdbg]
+schema_version = 2
+
+[tool.qtcdbg.project]
+name = "test"
+relative_root = "."
+
+[tool.qtcdbg.run]
+working_dir = "."
+executable_path = "test"
+bogus = true
+`)
+
+	cfg, err := parseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Project.Name != "test" {
+		t.Errorf("project.name is %q, want \"test\"", cfg.Project.Name)
+	}
+
+	found := false
+	for _, problem := range cfg.Misc.Problems {
+		if problem.key == "run.bogus" {
+			found = true
+			if problem.line != 14 {
+				t.Errorf("run.bogus is reported on line %d, want 14", problem.line)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("run.bogus is not reported as unknown")
+	}
+}
+
+// executable_path = "auto" is found in each run's own working dir, not
+// just for [run]
+func TestParseConfigRunsAutoExecutable(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("executables are only found on linux")
+	}
+
+	dir := t.TempDir()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolPath := filepath.Join(dir, "tools", "tool")
+	err = os.Mkdir(filepath.Dir(toolPath), 0755)
+	if err == nil {
+		err = os.WriteFile(toolPath, data, 0755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeTestFile(t, dir, "qtcdbg.toml", `schema_version = 2
+
+[project]
+name = "test"
+relative_root = "."
+
+[run]
+working_dir = "."
+executable_path = "tools/tool"
+
+[[runs]]
+name = "tool"
+working_dir = "tools"
+executable_path = "auto"
+`)
+
+	cfg, err := parseConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, problem := range cfg.Misc.Problems {
+		t.Errorf("unexpected problem: %v", problem)
+	}
+
+	run := cfg.Misc.RunConfigs[1]
+	if run.ExecutablePath != toolPath {
+		t.Errorf("runs.executable_path is %q, want %q", run.ExecutablePath, toolPath)
+	}
+}
@@ -0,0 +1,332 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config files are TOML or, if they end in .json, JSON with the same
+// keys.  A pyproject.toml holds the config in its [tool.qtcdbg] table.
+// All of them decode to the raw map that config layers are merged from,
+// with JSON values converted to the types the TOML decoder produces,
+// so everything past decoding works the same for each.
+
+// the table a pyproject.toml keeps the config in
+const pyprojectTable = "tool.qtcdbg"
+
+func isJsonConfig(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// pyproject.toml, and its local override, keep the config in
+// pyprojectTable
+func isPyprojectConfig(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	return name == "pyproject.toml" || name == "pyproject.local.toml"
+}
+
+// getConfigTableKey is key within the file at path, which is key
+// itself except in a pyproject.toml
+func getConfigTableKey(path string, key toml.Key) toml.Key {
+	if !isPyprojectConfig(path) {
+		return key
+	}
+
+	return append(strings.Split(pyprojectTable, "."), key...)
+}
+
+// hasPyprojectConfig reports whether the pyproject.toml at path has a
+// [tool.qtcdbg] table to use as the config
+func hasPyprojectConfig(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	_, err = decodeConfigData(path, data)
+	return err == nil
+}
+
+// parseTomlConfigDocument is parseTomlDocument for the config file at
+// path, with its tables inside pyprojectTable for a pyproject.toml
+func parseTomlConfigDocument(path string, data []byte) *tomlDocument {
+	doc := parseTomlDocument(data)
+	if isPyprojectConfig(path) {
+		doc.prefix = pyprojectTable
+	}
+
+	return doc
+}
+
+// decodeConfigData decodes the contents of the config file at path into
+// a raw map.  Errors point at the line they occur on.
+func decodeConfigData(path string, data []byte) (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+
+	if isPyprojectConfig(path) {
+		_, err := toml.Decode(string(data), &raw)
+		if err != nil {
+			return nil, formatDecodeError(path, err)
+		}
+
+		tool, _ := raw["tool"].(map[string]interface{})
+		table, ok := tool["qtcdbg"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: no [%s] table", path, pyprojectTable)
+		}
+
+		return table, nil
+	}
+
+	if !isJsonConfig(path) {
+		_, err := toml.Decode(string(data), &raw)
+		if err != nil {
+			return nil, formatDecodeError(path, err)
+		}
+
+		return raw, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err == nil {
+		// only one value is allowed in the file
+		if _, trailingErr := decoder.Token(); trailingErr != io.EOF {
+			err = fmt.Errorf("unexpected data after the top level object")
+		}
+	}
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%s:%d: %v", path, getOffsetLine(data, syntaxErr.Offset), err)
+		}
+
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	table, ok := normalizeJsonValue(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: the config must be a JSON object", path)
+	}
+
+	// editors use "$schema" to find the schema to validate against
+	delete(table, "$schema")
+
+	return table, nil
+}
+
+// normalizeJsonValue converts decoded JSON into what the TOML decoder
+// would produce for the same value: integers are int64, arrays of
+// objects are arrays of tables, and nulls are left out.
+func normalizeJsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		n, _ := value.Float64()
+		return n
+
+	case map[string]interface{}:
+		for name, item := range value {
+			if item == nil {
+				delete(value, name)
+				continue
+			}
+			value[name] = normalizeJsonValue(item)
+		}
+		return value
+
+	case []interface{}:
+		tables := make([]map[string]interface{}, 0, len(value))
+		for i, item := range value {
+			value[i] = normalizeJsonValue(item)
+			if table, ok := value[i].(map[string]interface{}); ok {
+				tables = append(tables, table)
+			}
+		}
+
+		if len(value) > 0 && len(tables) == len(value) {
+			return tables
+		}
+		return value
+	}
+
+	return value
+}
+
+// getOffsetLine is the 1-based line of a byte offset into data
+func getOffsetLine(data []byte, offset int64) int {
+	offset = min(max(offset, 0), int64(len(data)))
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// findJsonKeyLine returns the 1-based line that defines key in JSON
+// data, or 0 if it can't be found.  Keys inside an array of objects
+// are found in the first object that has them.
+func findJsonKeyLine(data []byte, key []string) int {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	line := 0
+
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				nameToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+
+				name, _ := nameToken.(string)
+				namePath := append(path[:len(path):len(path)], name)
+				if line == 0 && slices.Equal(namePath, key) {
+					line = getOffsetLine(data, decoder.InputOffset())
+				}
+
+				err = walk(namePath)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = decoder.Token()
+			return err
+
+		case json.Delim('['):
+			for decoder.More() {
+				err = walk(path)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = decoder.Token()
+			return err
+		}
+
+		return nil
+	}
+
+	walk(nil)
+	return line
+}
+
+// matches the position the TOML decoder puts in front of its messages,
+// capturing the last key it decoded
+var reTomlErrorPosition = regexp.MustCompile(`^toml: (?:line \d+ ?)?(?:\(last key "([^"]*)"\))?: `)
+
+// formatLayerDecodeError is formatDecodeError for an error decoding a
+// layer into a TomlConfig.  JSON and pyproject layers are decoded
+// through TOML encoded from their raw map, so the line the TOML decoder
+// reports is replaced with the key's line in the file.
+func formatLayerDecodeError(layer *configLayer, err error) error {
+	if !isJsonConfig(layer.path) && !isPyprojectConfig(layer.path) {
+		return formatDecodeError(layer.path, err)
+	}
+
+	match := reTomlErrorPosition.FindStringSubmatch(err.Error())
+	if match == nil {
+		return fmt.Errorf("%s: %v", layer.path, err)
+	}
+
+	message := strings.TrimPrefix(err.Error(), match[0])
+	lastKey := match[1]
+	if lastKey == "" {
+		return fmt.Errorf("%s: %s", layer.path, message)
+	}
+
+	line := findKeyLine(layer.path, layer.data, strings.Split(lastKey, "."))
+	return fmt.Errorf("%s:%d: %s: %s", layer.path, line, lastKey, message)
+}
+
+// jsonDocument is a JSON config that configMigrations can edit.  Keys
+// are renamed in the decoded map, and the file itself is rewritten
+// from the map if it is saved.
+type jsonDocument struct {
+	data []byte
+	raw  map[string]interface{}
+}
+
+func (doc *jsonDocument) getTable(table string) map[string]interface{} {
+	if table == "" {
+		return doc.raw
+	}
+
+	tableMap, _ := doc.raw[table].(map[string]interface{})
+	return tableMap
+}
+
+// findKey returns the index of the line defining key in table, or -1
+func (doc *jsonDocument) findKey(table, key string) int {
+	if _, ok := doc.getTable(table)[key]; !ok {
+		return -1
+	}
+
+	keyPath := []string{key}
+	if table != "" {
+		keyPath = []string{table, key}
+	}
+
+	return max(findJsonKeyLine(doc.data, keyPath)-1, 0)
+}
+
+func (doc *jsonDocument) renameKey(table, from, to string) bool {
+	tableMap := doc.getTable(table)
+	value, ok := tableMap[from]
+	if !ok {
+		return false
+	}
+
+	delete(tableMap, from)
+	tableMap[to] = value
+
+	return true
+}
+
+func (doc *jsonDocument) getString(table, key string) (string, bool) {
+	value, ok := doc.getTable(table)[key].(string)
+	return value, ok
+}
+
+// setString sets a key that is already in table
+func (doc *jsonDocument) setString(table, key, value string) error {
+	tableMap := doc.getTable(table)
+	if tableMap == nil {
+		return fmt.Errorf("no %s table to set %s in", table, key)
+	}
+
+	tableMap[key] = value
+	return nil
+}
+
+func (doc *jsonDocument) Bytes() ([]byte, error) {
+	data, err := json.MarshalIndent(doc.raw, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,168 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// a detectedBuild is a way to build the project that init found in the
+// project root.  Paths are relative to the project root.
+type detectedBuild struct {
+	system     string // name of the build system, like "CMake"
+	workingDir string
+	command    string
+	arguments  string
+
+	// directory holding compile_commands.json, or "" if there isn't one
+	compileCommandsDir string
+
+	// build dir a CMake or Meson configure step generated, or ""
+	configuredDir string
+}
+
+func (build detectedBuild) String() string {
+	command := build.command
+	if build.arguments != "" {
+		command += " " + build.arguments
+	}
+
+	return fmt.Sprintf("%s: %s, in %s", build.system, command, build.workingDir)
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// the compile database directory for a build that writes one to dir
+func getCompileCommandsDir(root, dir string) string {
+	if isFile(filepath.Join(root, dir, "compile_commands.json")) {
+		return dir
+	}
+
+	return ""
+}
+
+// getGeneratedBuildDirs returns the directories under root, and under
+// root/build, that contain marker, which a configure step leaves in
+// the directory it generates a build into
+func getGeneratedBuildDirs(root, marker string) []string {
+	var dirs []string
+
+	for _, parent := range []string{".", "build"} {
+		entries, err := os.ReadDir(filepath.Join(root, parent))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+
+			dir := filepath.Join(parent, entry.Name())
+			if _, err := os.Stat(filepath.Join(root, dir, marker)); err == nil {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	sort.Strings(dirs)
+	return dirs
+}
+
+// detectBuildSystems looks for the build systems a project in root
+// uses, in the order they are best offered.  Generated build
+// directories are preferred over the generator that made them, since
+// they are ready to build.
+func detectBuildSystems(root string) []detectedBuild {
+	var builds []detectedBuild
+
+	if isFile(filepath.Join(root, "CMakeLists.txt")) {
+		cacheDirs := getGeneratedBuildDirs(root, "CMakeCache.txt")
+		for _, dir := range cacheDirs {
+			builds = append(builds, detectedBuild{
+				system:             "CMake",
+				workingDir:         dir,
+				command:            "cmake",
+				arguments:          "--build .",
+				compileCommandsDir: getCompileCommandsDir(root, dir),
+				configuredDir:      dir,
+			})
+		}
+
+		if len(cacheDirs) == 0 {
+			builds = append(builds, detectedBuild{
+				system:     "CMake",
+				workingDir: ".",
+				command:    "cmake",
+				arguments:  "--build build",
+			})
+		}
+	}
+
+	if isFile(filepath.Join(root, "meson.build")) {
+		for _, dir := range getGeneratedBuildDirs(root, "meson-private") {
+			builds = append(builds, detectedBuild{
+				system:             "Meson",
+				workingDir:         ".",
+				command:            "meson",
+				arguments:          "compile -C " + filepath.ToSlash(dir),
+				compileCommandsDir: getCompileCommandsDir(root, dir),
+				configuredDir:      dir,
+			})
+		}
+	}
+
+	if isFile(filepath.Join(root, "premake5.lua")) {
+		gmakeDirs, _ := filepath.Glob(filepath.Join(root, "build", "gmake*"))
+		sort.Strings(gmakeDirs)
+		for _, gmakeDir := range gmakeDirs {
+			dir, _ := filepath.Rel(root, gmakeDir)
+			if !isFile(filepath.Join(gmakeDir, "Makefile")) {
+				continue
+			}
+
+			builds = append(builds, detectedBuild{
+				system:             "premake",
+				workingDir:         dir,
+				command:            "make",
+				compileCommandsDir: getCompileCommandsDir(root, dir),
+			})
+		}
+	}
+
+	// build files in the root itself
+	rootBuilds := []struct {
+		files   []string
+		system  string
+		command string
+	}{
+		{[]string{"GNUmakefile", "makefile", "Makefile"}, "make", "make"},
+		{[]string{"build.ninja"}, "Ninja", "ninja"},
+		{[]string{"SConstruct"}, "SCons", "scons"},
+	}
+	for _, rootBuild := range rootBuilds {
+		for _, file := range rootBuild.files {
+			if !isFile(filepath.Join(root, file)) {
+				continue
+			}
+
+			builds = append(builds, detectedBuild{
+				system:             rootBuild.system,
+				workingDir:         ".",
+				command:            rootBuild.command,
+				compileCommandsDir: getCompileCommandsDir(root, "."),
+			})
+			break
+		}
+	}
+
+	return builds
+}
@@ -0,0 +1,300 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// executable_path is set to this to debug the newest executable found
+// in the build directories when launching
+const autoExecutable = "auto"
+
+// directories executables are commonly built to, relative to the
+// project root
+var executableSearchDirs = []string{"bin", "build", "builddir", "out", "target", "_build", "cmake-build-*"}
+
+// how many directories below each search directory are looked in
+const executableSearchDepth = 4
+
+// directories in build trees that hold executables which aren't the
+// project's, like the compiler checks in CMakeFiles
+var executableSkipDirs = []string{"CMakeFiles", "meson-private", "meson-logs"}
+
+// an executableCandidate is an executable found in the project's build
+// directories that the user might want to debug
+type executableCandidate struct {
+	path      string // relative to the project root
+	hasDwarf  bool
+	modTime   time.Time
+	nameScore int // how closely the file name matches the project name
+}
+
+func (exe executableCandidate) String() string {
+	if exe.modTime.IsZero() {
+		return fmt.Sprintf("%s (not built yet)", exe.path)
+	}
+
+	debugInfo := "no debug info"
+	if exe.hasDwarf {
+		debugInfo = "debug info"
+	}
+
+	return fmt.Sprintf("%s (%s, built %s)", exe.path, debugInfo, exe.modTime.Format("2006-01-02 15:04"))
+}
+
+// lower case letters and digits only, so "My-Game" matches "mygame_d"
+func simplifyName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// getNameScore rates how closely an executable's file name matches the
+// project name, from 0 for no match to 3 for the same name
+func getNameScore(fileName, projectName string) int {
+	name := simplifyName(strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+	project := simplifyName(projectName)
+
+	switch {
+	case project == "" || name == "":
+		return 0
+	case name == project:
+		return 3
+	case strings.HasPrefix(name, project) || strings.HasPrefix(project, name):
+		return 2
+	case strings.Contains(name, project) || strings.Contains(project, name):
+		return 1
+	}
+
+	return 0
+}
+
+// readElfExecutable reports whether path is an ELF executable and
+// whether it has DWARF debug info.  Shared libraries are not
+// executables; position independent executables are told apart from
+// them by their program interpreter.
+func readElfExecutable(path string) (isExecutable, hasDwarf bool) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer file.Close()
+
+	switch file.Type {
+	case elf.ET_EXEC:
+		isExecutable = true
+	case elf.ET_DYN:
+		for _, prog := range file.Progs {
+			if prog.Type == elf.PT_INTERP {
+				isExecutable = true
+			}
+		}
+	}
+
+	hasDwarf = file.Section(".debug_info") != nil || file.Section(".zdebug_info") != nil
+
+	return isExecutable, hasDwarf
+}
+
+// getExecutableSearchDirs lists the directories under root to look for
+// executables in: the common build directories, plus extra, which are
+// relative to root.  Only directories that exist are listed.
+func getExecutableSearchDirs(root string, extra ...string) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+
+	add := func(dir string) {
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			return
+		}
+		seen[dir] = true
+
+		if info, err := os.Stat(filepath.Join(root, dir)); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range extra {
+		if dir != "" && !filepath.IsAbs(dir) {
+			add(dir)
+		}
+	}
+
+	for _, pattern := range executableSearchDirs {
+		matches, _ := filepath.Glob(filepath.Join(root, pattern))
+		for _, match := range matches {
+			dir, err := filepath.Rel(root, match)
+			if err == nil {
+				add(dir)
+			}
+		}
+	}
+
+	return dirs
+}
+
+// findExecutables looks for ELF executables in dirs, which are relative
+// to root, and ranks them: those with debug info first, then those
+// named most like the project, then the newest.  In the project root
+// itself, which holds the sources, only the files directly in it are
+// looked at.
+func findExecutables(root string, dirs []string, projectName string) []executableCandidate {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return nil
+	}
+
+	var candidates []executableCandidate
+	seen := make(map[string]bool)
+
+	for _, dir := range dirs {
+		depth := executableSearchDepth
+		if dir == "." {
+			depth = 0
+		}
+
+		filepath.WalkDir(filepath.Join(root, dir), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return nil
+			}
+
+			if entry.IsDir() {
+				sub, _ := filepath.Rel(filepath.Join(root, dir), path)
+				if sub == "." {
+					return nil
+				}
+
+				isSkipped := strings.HasPrefix(entry.Name(), ".") ||
+					slices.Contains(executableSkipDirs, entry.Name())
+				if isSkipped || strings.Count(filepath.ToSlash(sub), "/")+1 > depth {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// search dirs can be nested in each other
+			if seen[rel] {
+				return nil
+			}
+			seen[rel] = true
+
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				return nil
+			}
+
+			isExecutable, hasDwarf := readElfExecutable(path)
+			if !isExecutable {
+				return nil
+			}
+
+			candidates = append(candidates, executableCandidate{
+				path:      filepath.ToSlash(rel),
+				hasDwarf:  hasDwarf,
+				modTime:   info.ModTime(),
+				nameScore: getNameScore(entry.Name(), projectName),
+			})
+
+			return nil
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.hasDwarf != b.hasDwarf {
+			return a.hasDwarf
+		}
+		if a.nameScore != b.nameScore {
+			return a.nameScore > b.nameScore
+		}
+		return a.modTime.After(b.modTime)
+	})
+
+	return candidates
+}
+
+// pickAutoExecutable picks the executable to debug for
+// executable_path = "auto": the newest one, preferring those with
+// debug info and then those named like the project
+func pickAutoExecutable(candidates []executableCandidate) (executableCandidate, bool) {
+	prefer := func(keep func(executableCandidate) bool) {
+		var kept []executableCandidate
+		for _, candidate := range candidates {
+			if keep(candidate) {
+				kept = append(kept, candidate)
+			}
+		}
+
+		if len(kept) != 0 {
+			candidates = kept
+		}
+	}
+
+	prefer(func(exe executableCandidate) bool { return exe.hasDwarf })
+	prefer(func(exe executableCandidate) bool { return exe.nameScore > 0 })
+
+	if len(candidates) == 0 {
+		return executableCandidate{}, false
+	}
+
+	newest := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.modTime.After(newest.modTime) {
+			newest = candidate
+		}
+	}
+
+	return newest, true
+}
+
+// resolveAutoExecutable replaces executable_path = "auto" in run with
+// the executable pickAutoExecutable finds in its run directory and the
+// build directory.  key is where the run is in the config, for the
+// warning left when none is found, since there is none to find before
+// the first build.
+func resolveAutoExecutable(cfg *TomlConfig, run *runConfig, key string) []configProblem {
+	searchDirs := getExecutableSearchDirs(cfg.Misc.ProjectRoot, run.WorkingDir, cfg.Build.WorkingDir)
+	exe, ok := pickAutoExecutable(findExecutables(cfg.Misc.ProjectRoot, searchDirs, cfg.Project.Name))
+	if !ok {
+		message := fmt.Sprintf("is \"%s\", but no executable was found in %s", autoExecutable, strings.Join(searchDirs, ", "))
+		if run.Name != "" {
+			message += fmt.Sprintf(" for run \"%s\"", run.Name)
+		}
+
+		path, line := locateConfigKey(cfg, key)
+		return []configProblem{{
+			path:    path,
+			line:    line,
+			key:     key,
+			message: message,
+		}}
+	}
+
+	if *debug {
+		fmt.Printf("Found executable %s\n", exe)
+	}
+	run.ExecutablePath = exe.path
+
+	return nil
+}
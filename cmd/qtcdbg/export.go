@@ -0,0 +1,320 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// a launch.json configuration for the cppdbg debugger of VS Code's C++
+// extension, with its keys in the order VS Code writes them
+type vscodeLaunchEntry struct {
+	Name            string                      `json:"name"`
+	Type            string                      `json:"type"`
+	Request         string                      `json:"request"`
+	Program         string                      `json:"program"`
+	Args            []string                    `json:"args"`
+	Cwd             string                      `json:"cwd"`
+	Environment     []vscodeEnvironmentVariable `json:"environment"`
+	ExternalConsole bool                        `json:"externalConsole"`
+	MIMode          string                      `json:"MIMode"`
+	SetupCommands   []vscodeSetupCommand        `json:"setupCommands"`
+}
+
+type vscodeEnvironmentVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type vscodeSetupCommand struct {
+	Description    string `json:"description,omitempty"`
+	Text           string `json:"text"`
+	IgnoreFailures bool   `json:"ignoreFailures,omitempty"`
+}
+
+// getExportRuns picks the run configs to export: the [[runs]] entry
+// named name, or with no name, [run] and, if all is set, every entry
+// in [[runs]]
+func getExportRuns(cfg *TomlConfig, name string, all bool) ([]runConfig, error) {
+	if name == "" {
+		if all {
+			return cfg.Misc.RunConfigs, nil
+		}
+		return cfg.Misc.RunConfigs[:1], nil
+	}
+
+	for _, run := range cfg.Misc.RunConfigs[1:] {
+		if run.Name == name {
+			return []runConfig{run}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("there is no [[runs]] entry named \"%s\"", name)
+}
+
+// sorted names of the variables in a run's environment, so exports
+// don't change from one run to the next
+func getEnvironmentNames(run runConfig) []string {
+	names := make([]string, 0, len(run.Environment))
+	for name := range run.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// translateVscodeVariables rewrites a value as written in the config
+// with VS Code's variables, so launch.json expands it on the machine it
+// is used on.  It fails for variables VS Code has no equivalent for.
+func translateVscodeVariables(s string) (string, bool) {
+	translated := true
+	out := reVariable.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		name := strings.TrimSpace(match[2 : len(match)-1])
+		switch {
+		case name == "project_root":
+			return "${workspaceFolder}"
+		case strings.HasPrefix(name, "env:"):
+			return "${" + name + "}"
+		}
+
+		translated = false
+		return match
+	})
+
+	return out, translated
+}
+
+// exportVscode writes launch.json.  Paths under the project root are
+// written relative to ${workspaceFolder}, so the file can be shared
+// when the workspace is the project root.
+func exportVscode(cfg *TomlConfig, runs []runConfig) (string, error) {
+	inWorkspace := func(s string) string {
+		root := cfg.Misc.ProjectRoot
+		if s == root {
+			return "${workspaceFolder}"
+		}
+
+		if rest, ok := strings.CutPrefix(s, root+string(filepath.Separator)); ok {
+			return "${workspaceFolder}/" + filepath.ToSlash(rest)
+		}
+
+		return s
+	}
+
+	miMode := "gdb"
+	if runtime.GOOS == "darwin" {
+		miMode = "lldb"
+	}
+
+	var launch struct {
+		Version        string              `json:"version"`
+		Configurations []vscodeLaunchEntry `json:"configurations"`
+	}
+	launch.Version = "0.2.0"
+
+	for _, run := range runs {
+		entry := vscodeLaunchEntry{
+			Name:            run.Name,
+			Type:            "cppdbg",
+			Request:         "launch",
+			Program:         inWorkspace(run.ExecutablePath),
+			Args:            []string{},
+			Cwd:             inWorkspace(run.WorkingDir),
+			Environment:     []vscodeEnvironmentVariable{},
+			ExternalConsole: run.RunInTerminal,
+			MIMode:          miMode,
+			SetupCommands: []vscodeSetupCommand{{
+				Description:    "Enable pretty-printing for gdb",
+				Text:           "-enable-pretty-printing",
+				IgnoreFailures: true,
+			}},
+		}
+		if entry.Name == "" {
+			entry.Name = cfg.Project.Name
+		}
+
+		for _, arg := range run.Arguments.getList() {
+			entry.Args = append(entry.Args, inWorkspace(arg))
+		}
+
+		// the environment is written unexpanded where VS Code can
+		// expand it, rather than with this machine's values
+		for _, name := range getEnvironmentNames(run) {
+			value, ok := translateVscodeVariables(run.rawEnvironment[name])
+			if _, written := run.rawEnvironment[name]; !written || !ok {
+				value = inWorkspace(run.Environment[name])
+			}
+
+			entry.Environment = append(entry.Environment, vscodeEnvironmentVariable{
+				Name:  name,
+				Value: value,
+			})
+		}
+
+		for _, command := range run.DebuggerStartup {
+			entry.SetupCommands = append(entry.SetupCommands, vscodeSetupCommand{Text: command})
+		}
+
+		launch.Configurations = append(launch.Configurations, entry)
+	}
+
+	out, err := json.MarshalIndent(launch, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out) + "\n", nil
+}
+
+// quote a path for a gdb command, which only needs it when the path has
+// spaces
+func quoteGdbPath(path string) string {
+	if !strings.ContainsAny(path, " \t\"") {
+		return path
+	}
+
+	return `"` + strings.ReplaceAll(path, `"`, `\"`) + `"`
+}
+
+// exportGdbinit writes a gdb command file, run with gdb -x, that loads
+// the executable and sets up its arguments, working directory and
+// environment the way QtCreator would
+func exportGdbinit(cfg *TomlConfig, run runConfig) string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "# generated by \"qtcdbg export\" from %s\n", cfg.Misc.cfgPath)
+	fmt.Fprintf(&out, "file %s\n", quoteGdbPath(run.ExecutablePath))
+
+	// gdb starts the program with a shell, which parses the arguments
+	quoted := make([]string, 0)
+	for _, arg := range run.Arguments.getList() {
+		quoted = append(quoted, quoteArgUnix(arg))
+	}
+	if len(quoted) != 0 {
+		fmt.Fprintf(&out, "set args %s\n", strings.Join(quoted, " "))
+	}
+
+	fmt.Fprintf(&out, "set cwd %s\n", run.WorkingDir)
+
+	for _, name := range getEnvironmentNames(run) {
+		fmt.Fprintf(&out, "set environment %s=%s\n", name, run.Environment[name])
+	}
+
+	for _, command := range run.DebuggerStartup {
+		fmt.Fprintln(&out, command)
+	}
+
+	return out.String()
+}
+
+// quote an argument for an lldb command
+func quoteLldbArg(arg string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(arg)
+	return `"` + escaped + `"`
+}
+
+// exportLldbinit writes an lldb command file, run with lldb -s.  The
+// debugger startup commands are gdb commands, so they are left out.
+func exportLldbinit(cfg *TomlConfig, run runConfig) string {
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "# generated by \"qtcdbg export\" from %s\n", cfg.Misc.cfgPath)
+	fmt.Fprintf(&out, "target create %s\n", quoteLldbArg(run.ExecutablePath))
+
+	var args []string
+	for _, arg := range run.Arguments.getList() {
+		args = append(args, quoteLldbArg(arg))
+	}
+	if len(args) != 0 {
+		fmt.Fprintf(&out, "settings set -- target.run-args %s\n", strings.Join(args, " "))
+	}
+
+	fmt.Fprintf(&out, "platform settings -w %s\n", quoteLldbArg(run.WorkingDir))
+
+	var variables []string
+	for _, name := range getEnvironmentNames(run) {
+		variables = append(variables, quoteLldbArg(name+"="+run.Environment[name]))
+	}
+	if len(variables) != 0 {
+		fmt.Fprintf(&out, "settings set target.env-vars %s\n", strings.Join(variables, " "))
+	}
+
+	if len(run.DebuggerStartup) != 0 {
+		fmt.Fprintf(os.Stderr, "debugger_startup holds gdb commands, so it is left out of the lldb command file\n")
+	}
+
+	return out.String()
+}
+
+// Export writes the run configuration in the config for another
+// debugger, so the config can be the one place it is kept
+func Export() int {
+	actualConfigPath, err := findConfig(*exportConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		return 1
+	}
+
+	cfg, err := parseConfig(actualConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	printConfigProblems(cfg.Misc.Problems)
+	if hasConfigErrors(cfg.Misc.Problems) {
+		fmt.Fprintf(os.Stderr, "Fix the errors in %s, or run \"qtcdbg check\" for details.\n", actualConfigPath)
+		return 1
+	}
+
+	runs, err := getExportRuns(&cfg, *exportRun, *exportFormat == "vscode")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", actualConfigPath, err)
+		return 1
+	}
+
+	var out string
+	switch *exportFormat {
+	case "vscode":
+		out, err = exportVscode(&cfg, runs)
+	case "gdbinit":
+		out = exportGdbinit(&cfg, runs[0])
+	case "lldbinit":
+		out = exportLldbinit(&cfg, runs[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	if *exportOut == "" {
+		fmt.Print(out)
+		return 0
+	}
+
+	err = os.MkdirAll(filepath.Dir(*exportOut), 0755)
+	if err == nil {
+		err = writeFileAtomic(*exportOut, []byte(out))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write %s: %v\n", *exportOut, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", *exportOut)
+
+	return 0
+}
@@ -5,14 +5,41 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 )
 
+// a generatedFile is one of the files that make up the adhoc QtCreator project.
+// render produces the full contents of the file; it must be deterministic so
+// that regenerating an unchanged project produces byte-identical output.
+type generatedFile struct {
+	suffix string
+	render func(cfg *TomlConfig) (string, error)
+}
+
+// generatedFiles lists every file in the adhoc project.  It is a
+// function rather than a table because renderFiles needs the list too.
+func generatedFiles() []generatedFile {
+	return []generatedFile{
+		{".cflags", renderFlags},
+		{".config", renderConfig},
+		{".creator", renderCreator},
+		{".cxxflags", renderCxxFlags},
+		{".files", renderFiles},
+		{".includes", renderIncludes},
+		{".creator.user", renderCreatorUser},
+	}
+}
+
 func getProjectRoot(cfg *TomlConfig) string {
 	cfgDir, _ := filepath.Split(cfg.Misc.cfgPath)
 	projectRoot := filepath.Join(cfgDir, cfg.Project.RelativeRoot)
@@ -26,218 +53,308 @@ func getProjectRoot(cfg *TomlConfig) string {
 	return projectRoot
 }
 
-func getGeneratorPath(cfg *TomlConfig, filename string) string {
-	cfgDir, _ := filepath.Split(cfg.Misc.cfgPath)
-	return filepath.Join(cfgDir, filename)
-}
+// resolve where the generated project files are written.  In order of
+// precedence: the --out flag, [generate] output_dir relative to the
+// config file ("." keeps the files next to the config file), or a per
+// project directory in the user cache dir so the source tree stays
+// clean.
+func resolveOutputDir(cfg *TomlConfig, userOut string) (string, error) {
+	var outputDir string
+
+	switch {
+	case userOut != "":
+		outputDir = userOut
+
+	case cfg.Generate.OutputDir != "":
+		cfgDir, _ := filepath.Split(cfg.Misc.cfgPath)
+		outputDir = filepath.Join(cfgDir, cfg.Generate.OutputDir)
+
+	default:
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("No user cache dir, set [generate] output_dir: %v", err)
+		}
+
+		// the project root hash keeps two checkouts of the same
+		// project from sharing a directory
+		rootHash := sha1.Sum([]byte(cfg.Misc.ProjectRoot))
+		projectDir := fmt.Sprintf("%s-%x", cfg.Project.Name, rootHash[:4])
+		outputDir = filepath.Join(cacheDir, "qtcdbg", projectDir)
+	}
 
-func createFile(cfg *TomlConfig, suffix string) (*os.File, error) {
-	filename := cfg.Project.Name + suffix
-	return os.Create(getGeneratorPath(cfg, filename))
-}
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", err
+	}
 
-func GenerateConfig(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".config")
+	err = os.MkdirAll(outputDir, 0755)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer f.Close()
 
-	body := "// generated by qtcdbg\n"
-	for _, def := range cfg.Generate.ConfigDefines {
-		body += fmt.Sprintf("#define %s\n", def)
+	if *debug {
+		fmt.Printf("Output dir: %s\n", outputDir)
 	}
 
-	f.WriteString(body)
+	return outputDir, nil
+}
+
+func getGeneratorPath(cfg *TomlConfig, filename string) string {
+	return filepath.Join(cfg.Misc.OutputDir, filename)
+}
+
+// paths in .files and .includes are resolved by QtCreator relative to
+// the generated files, so they are prefixed with the path from the
+// output dir back to the project root.
+func getRootFromOutputDir(cfg *TomlConfig) string {
+	rootFromOutput, err := filepath.Rel(cfg.Misc.OutputDir, cfg.Misc.ProjectRoot)
+	if err != nil {
+		// different volumes on windows
+		return cfg.Misc.ProjectRoot
+	}
 
-	return nil
+	return rootFromOutput
 }
 
-func GenerateFlags(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".cflags")
+// write a file by renaming a complete temp file over it, so that a
+// reader never sees it partially written
+func writeFileAtomic(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".qtcdbg-*.tmp")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer os.Remove(tempFile.Name())
 
-	body := "// generated by qtcdbg\n"
-	for _, cflag := range cfg.Generate.ConfigCFlags {
-		body += fmt.Sprintf("%s\n", cflag)
+	_, err = tempFile.Write(data)
+	if err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		return err
 	}
 
-	f.WriteString(body)
+	err = os.Chmod(tempFile.Name(), 0644)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return os.Rename(tempFile.Name(), path)
 }
 
-func GenerateCreator(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".creator")
+func writeGenerated(cfg *TomlConfig, gen generatedFile) error {
+	body, err := gen.render(cfg)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	body := "[General]\n"
-	f.WriteString(body)
+	return writeFileAtomic(getGeneratorPath(cfg, cfg.Project.Name+gen.suffix), []byte(body))
+}
+
+// GenerateProjectFiles writes every generated file, carrying on past
+// failures so that all of them are reported at once.  The returned
+// error joins one error per failed file.
+func GenerateProjectFiles(cfg *TomlConfig) error {
+	var errs []error
+
+	for _, gen := range generatedFiles() {
+		err := writeGenerated(cfg, gen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cfg.Project.Name+gen.suffix, err))
+		}
+	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func GenerateCxxFlags(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".cxxflags")
-	if err != nil {
-		return err
+// normalizePaths returns paths with forward slashes, cleaned, sorted
+// and with duplicates removed.  QtCreator accepts forward slashes on
+// all platforms, so this keeps the output identical across machines.
+func normalizePaths(paths []string) []string {
+	normalized := make([]string, 0, len(paths))
+	seen := make(map[string]bool)
+
+	for _, p := range paths {
+		p = path.Clean(filepath.ToSlash(p))
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		normalized = append(normalized, p)
 	}
-	defer f.Close()
 
-	// empty file
+	sort.Strings(normalized)
 
-	return nil
+	return normalized
 }
 
-func GenerateFiles(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".files")
-	if err != nil {
-		return err
+func joinLines(lines []string) string {
+	var body strings.Builder
+	for _, line := range lines {
+		body.WriteString(line + "\n")
 	}
-	defer f.Close()
 
-	projectRoot := getProjectRoot(cfg)
-	// push/pop the path to ensure generate paths do not include
-	// the full directory structure
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
+	return body.String()
+}
+
+func renderConfig(cfg *TomlConfig) (string, error) {
+	body := "// generated by qtcdbg\n"
+	defines := cfg.Generate.ConfigDefines
+	if cfg.Misc.CMake != nil {
+		defines = append(defines[:len(defines):len(defines)], cfg.Misc.CMake.defines...)
 	}
-	defer os.Chdir(cwd)
-	os.Chdir(projectRoot)
 
-	// just add almost all files under the project root.  because this
-	// qtc project will be used for debugging only, it is a low
-	// priority to cull this perfectly.
-	err = filepath.Walk(".",
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	for _, def := range defines {
+		body += fmt.Sprintf("#define %s\n", def)
+	}
 
-			// handles .git, etc
-			if strings.HasPrefix(path, ".") {
-				return nil
-			}
+	return body, nil
+}
 
-			if info.IsDir() {
-				return nil
-			}
+func renderFlags(cfg *TomlConfig) (string, error) {
+	body := "// generated by qtcdbg\n"
+	cflags := cfg.Generate.ConfigCFlags
+	if cfg.Misc.CMake != nil {
+		cflags = append(cflags[:len(cflags):len(cflags)], cfg.Misc.CMake.cflags...)
+	}
 
-			relativePath := filepath.Join(cfg.Project.RelativeRoot, path)
-			f.WriteString(relativePath + "\n")
+	for _, cflag := range cflags {
+		body += fmt.Sprintf("%s\n", cflag)
+	}
 
-			return nil
-		})
-	if err != nil {
-		return err
+	return body, nil
+}
+
+func renderCreator(cfg *TomlConfig) (string, error) {
+	return "[General]\n", nil
+}
+
+func renderCxxFlags(cfg *TomlConfig) (string, error) {
+	// empty unless the cmake build has c++ flags
+	if cfg.Misc.CMake == nil {
+		return "", nil
 	}
 
-	return err
+	return "// generated by qtcdbg\n" + joinLines(cfg.Misc.CMake.cxxflags), nil
 }
 
-func GenerateIncludes(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".includes")
-	if err != nil {
-		return err
+func renderFiles(cfg *TomlConfig) (string, error) {
+	if cfg.Misc.Scan == nil {
+		return "", errors.New("Project root has not been scanned")
+	}
+	rootFromOutput := getRootFromOutputDir(cfg)
+
+	// the generated files themselves may be under the project root, and
+	// must not show up in the listing or it would depend on whether a
+	// previous run left them behind.
+	generated := make(map[string]bool)
+	for _, gen := range generatedFiles() {
+		generated[getGeneratorPath(cfg, cfg.Project.Name+gen.suffix)] = true
 	}
-	defer f.Close()
 
-	projectRoot := getProjectRoot(cfg)
+	// just add almost all files under the project root.  because this
+	// qtc project will be used for debugging only, it is a low
+	// priority to cull this perfectly.
+	var files []string
+	for _, path := range cfg.Misc.Scan.Files {
+		if generated[filepath.Join(cfg.Misc.ProjectRoot, path)] {
+			continue
+		}
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		return err
+		files = append(files, filepath.Join(rootFromOutput, path))
 	}
-	defer os.Chdir(cwd)
-	os.Chdir(projectRoot)
+
+	return joinLines(normalizePaths(files)), nil
+}
+
+func renderIncludes(cfg *TomlConfig) (string, error) {
+	if cfg.Misc.Scan == nil {
+		return "", errors.New("Project root has not been scanned")
+	}
+	rootFromOutput := getRootFromOutputDir(cfg)
 
 	// headerFilesDirs contains all paths with header files in them.
-	// as with GenerateFiles above, this is a blunt way of going about
+	// as with renderFiles above, this is a blunt way of going about
 	// it, but since it is just used for header search paths for a
 	// quick debug session, it is better to just be inclusive here.
+	var headerFilesDirs []string
+	headerFilesDirs = append(headerFilesDirs, cfg.Misc.Scan.HeaderDirs...)
+	headerFilesDirs = append(headerFilesDirs, cfg.Generate.AdditionalIncludeSearchDirs...)
 
-	//headerFilesDirs := make([]string, 10)
-	headerFilesDirs := make(map[string]int)
-	err = filepath.Walk(".",
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if !info.IsDir() {
-				return nil
-			}
+	var includes []string
+	for _, path := range headerFilesDirs {
+		includes = append(includes, filepath.Join(rootFromOutput, path))
+	}
 
-			allFiles, err := ioutil.ReadDir(path)
-			if err != nil {
-				return err
-			}
-			for _, file := range allFiles {
-				if filepath.Ext(file.Name()) == ".h" {
-					headerFilesDirs[path] = 1
-				}
+	// the cmake build's include dirs are absolute.  Those in the
+	// project are written like the others, so the output is the same
+	// on every machine.
+	if cfg.Misc.CMake != nil {
+		for _, path := range cfg.Misc.CMake.includes {
+			rel, err := filepath.Rel(cfg.Misc.ProjectRoot, path)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				path = filepath.Join(rootFromOutput, rel)
 			}
-
-			return nil
-		})
-
-	for _, additionalPath := range cfg.Generate.AdditionalIncludeSearchDirs {
-		headerFilesDirs[additionalPath] = 1
+			includes = append(includes, path)
+		}
 	}
 
-	for path, _ := range headerFilesDirs {
-		relativePath := filepath.Join(cfg.Project.RelativeRoot, path)
-		f.WriteString(relativePath + "\n")
-	}
+	return joinLines(normalizePaths(includes)), nil
+}
 
-	return nil
+// escape a value for the text of an xml element.  Values are printed
+// with fmt first, so commandArgs become their quoted command line.
+func escapeXml(value interface{}) (string, error) {
+	var escaped strings.Builder
+	err := xml.EscapeText(&escaped, []byte(fmt.Sprint(value)))
+
+	return escaped.String(), err
 }
 
-func GenerateCreatorUser(cfg *TomlConfig) error {
-	f, err := createFile(cfg, ".creator.user")
+func renderCreatorUser(cfg *TomlConfig) (string, error) {
+	funcs := template.FuncMap{"xml": escapeXml, "join": strings.Join}
+	tmpl, err := template.New("creator").Funcs(funcs).Parse(*tmplCreator)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer f.Close()
 
-	tmpl, err := template.New("creator").Parse(*tmplCreator)
-	if err != nil {
-		return nil
-	}
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, cfg)
+
+	return body.String(), err
+}
 
-	err = tmpl.Execute(f, cfg)
+// CheckGeneratedFiles renders every generated file in memory and
+// compares it against what is on disk.  It returns the paths of the
+// files that are missing or would change on regeneration.
+func CheckGeneratedFiles(cfg *TomlConfig) ([]string, error) {
+	var stale []string
+
+	for _, gen := range generatedFiles() {
+		body, err := gen.render(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		path := getGeneratorPath(cfg, cfg.Project.Name+gen.suffix)
+		existing, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(existing, []byte(body)) {
+			stale = append(stale, path)
+		}
+	}
 
-	return err
+	return stale, nil
 }
 
 func CleanupGeneratedFiles(cfg *TomlConfig, skip bool) {
-	cfgDir, _ := filepath.Split(cfg.Misc.cfgPath)
-
 	if skip {
 		fmt.Printf("Skipping cleanup of generated files.\n")
 		return
 	}
-	extensions := []string{".cflags",
-		".config",
-		".creator",
-		".cxxflags",
-		".files",
-		".includes",
-		".creator.user",
-	}
-
-	for _, ext := range extensions {
-		filename := cfg.Project.Name + ext
-
-		path := filepath.Join(cfgDir, filename)
 
-		os.Remove(path)
+	for _, gen := range generatedFiles() {
+		os.Remove(getGeneratorPath(cfg, cfg.Project.Name+gen.suffix))
 	}
 }
@@ -5,13 +5,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/BurntSushi/toml"
 	"github.com/chzyer/readline"
 )
 
@@ -24,36 +28,71 @@ var tmplToml = `#
 # this file was generated with "qtcdbg init" and should be checked in to 
 # source control.
 
+# version of the config format.  "qtcdbg migrate" upgrades older files.
+schema_version = 2
+
 [project]
 
 # project name
-name = "{{ .Project.Name }}"
+name = {{ toml .Project.Name }}
 
 # project root relative to this config file
-relative_root = "{{ .Project.RelativeRoot }}"
+relative_root = {{ toml .Project.RelativeRoot }}
 
 [build]
 
 # directory to run build command in
-working_dir = "{{ .Build.WorkingDir }}"
+working_dir = {{ toml .Build.WorkingDir }}
 
-command = "{{ .Build.Command }}"
+command = {{ toml .Build.Command }}
 
-arguments = "{{ .Build.Arguments }}"
+arguments = {{ toml .Build.Arguments }}
 
 [run]
 
 # cwd while running the program
-working_dir = "{{ .Run.WorkingDir }}"
+working_dir = {{ toml .Run.WorkingDir }}
 
-# path including filename of executable to debug
-executable_path = "{{ .Run.ExecutablePath }}"
+# path including filename of executable to debug.  "auto" debugs the
+# newest executable found in the build directories.
+executable_path = {{ toml .Run.ExecutablePath }}
 
-# arguments to run with
-arguments = ""
+# arguments to run with.  a string is passed to qtcreator as written;
+# the arguments in an array are quoted for it.
+arguments = {{ toml .Run.Arguments }}
 
 # whether qtcreator should pop up a terminal
-run_in_terminal = true
+run_in_terminal = {{ toml .Run.RunInTerminal }}
+
+# debugger commands to run before the program starts, like
+# debugger_startup = ["set print pretty on"]
+
+# variables to set in the program's environment go in a table:
+# [run.environment]
+# GAME_DATA = "${project_root}/data"
+
+# more ways to run the program each get a [[runs]] table with a name
+# and the keys of [run].  "qtcdbg import vscode" writes them from
+# .vscode/launch.json.
+
+[cmake]
+
+# cmake build dir to read the CMake File API reply from.  the reply's
+# executable targets become run configurations, and its include dirs,
+# defines and flags are added to the generated project.  "" to not
+# use it.
+build_dir = {{ toml .CMake.BuildDir }}
+
+# executable target for [run] to debug, in place of executable_path
+target = {{ toml .CMake.Target }}
+
+[meson]
+
+# meson build dir to introspect.  its executables become run
+# configurations, and when build.command or compile_commands.dir are
+# "" it is built with "meson compile -C" and its compile_commands.json
+# is used.  "" to not use it.
+build_dir = {{ toml .Meson.BuildDir }}
 
 [generate]
 # qtcreator's syntax highlighting dims proprocessor paths not generated.
@@ -68,165 +107,832 @@ config_cflags = [
 additional_include_search_dirs = [
 ]
 
+# directory the generated QtCreator project files are written to,
+# relative to this config file.  defaults to a per-project directory
+# in the user cache dir.  "." writes them next to this config file.
+# output_dir = "."
+
+# dot directories like .git are never scanned.  skip_dirs lists more
+# directories to leave out of the project.  a name like "node_modules"
+# matches at any depth; a path like "third_party/*/test" is relative
+# to the project root.  both accept * and ? wildcards.
+skip_dirs = [
+]
+
+# follow symlinked directories when scanning the project.  symlink
+# loops are detected and skipped.
+follow_symlinks = false
+
+# leave the git submodules listed in .gitmodules out of the project
+skip_submodules = false
+
 [compile_commands]
 # if qtcreator is set up to use clangd, it will create its own .qtc_clangd/compile_commands.json
 # which is not a good match.  Set this to true to override compile_commands.json with
 # one supplied for your own project.  This helps warnings, defines and other compile flags
 # match your main development ones.
-override = false
+override = {{ toml .CompileCommands.Override }}
 
-# dir to compile_commands.json, relative to working dir
-path = "src/"
+# dir to compile_commands.json, relative to the project root
+dir = {{ toml .CompileCommands.Dir }}
 `
 
 var Separator = fmt.Sprintf("%c", filepath.Separator)
 
-func escape(s *string) string {
-	s2 := strings.ReplaceAll(*s, `\`, `\\`)
-	*s = s2
+// getRelativeRoot is relative_root for a config in configDir: the
+// project root relative to it, so the config works wherever the
+// project is checked out
+func getRelativeRoot(configDir, root string) (string, error) {
+	absConfigDir, err := filepath.Abs(configDir)
+	if err != nil {
+		return "", err
+	}
+
+	relativeRoot, err := filepath.Rel(absConfigDir, root)
+	if err != nil {
+		return "", err
+	}
 
-	return s2
+	return filepath.ToSlash(relativeRoot) + "/", nil
 }
 
-func askYesNo(rl *readline.Instance, question string) bool {
-	fmt.Println(question + " (y/N)")
+// renderInitConfig fills in tmplToml.  Values are written with the TOML
+// encoder, so any string survives, and the result is decoded again to
+// be sure it is valid before it is written.
+func renderInitConfig(cfg *TomlConfig) ([]byte, error) {
+	funcs := template.FuncMap{"toml": encodeTomlValue}
+	tmpl, err := template.New("config").Funcs(funcs).Parse(tmplToml)
+	if err != nil {
+		return nil, err
+	}
 
-	var line string
-	var err error
-	for {
-		line, err = rl.Readline()
-		if err != nil {
-			panic(err)
+	var config bytes.Buffer
+	err = tmpl.Execute(&config, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded TomlConfig
+	_, err = toml.Decode(config.String(), &decoded)
+	if err != nil {
+		return nil, fmt.Errorf("generated config is not valid: %v", err)
+	}
+
+	return config.Bytes(), nil
+}
+
+// the questions init can be given answers for up front, by flag name
+var initAnswerFlags = []struct {
+	name string
+	help string
+}{
+	{"name", "Project name"},
+	{"run-dir", "Working directory the debugged executable runs in, relative to the project root"},
+	{"exe", "Path of the debug executable, relative to the project root"},
+	{"args", "Command line arguments to debug the executable with"},
+	{"build-cmd", "Command that builds the project in QtCreator"},
+	{"build-dir", "Directory the build command runs in, relative to the project root"},
+	{"build-args", "Arguments to the build command"},
+}
+
+// the answers given up front, by flag name, from the answers file and
+// then the flags over it
+var initGivenAnswers = make(map[string]string)
+
+// an initAnswerFlag stores its value in initGivenAnswers, so a flag
+// given an empty value still counts as an answer
+type initAnswerFlag string
+
+func (flag initAnswerFlag) Set(value string) error {
+	initGivenAnswers[string(flag)] = value
+	return nil
+}
+
+func (flag initAnswerFlag) String() string {
+	return initGivenAnswers[string(flag)]
+}
+
+func init() {
+	for _, flag := range initAnswerFlags {
+		initCmd.Flag(flag.name, flag.help).SetValue(initAnswerFlag(flag.name))
+	}
+}
+
+// readInitAnswersFile reads answers keyed by flag name, with
+// underscores for dashes:
+//
+//	name = "game"
+//	run_dir = "bin/"
+//	yes = true
+//
+// Answers already given as flags are kept.
+func readInitAnswersFile(path string) error {
+	var answers map[string]interface{}
+	_, err := toml.DecodeFile(path, &answers)
+	if err != nil {
+		return formatDecodeError(path, err)
+	}
+
+	for key, value := range answers {
+		flag := strings.ReplaceAll(key, "_", "-")
+
+		if flag == "yes" {
+			yes, ok := value.(bool)
+			if !ok {
+				return fmt.Errorf("%s: yes must be true or false", path)
+			}
+			*initYes = *initYes || yes
+			continue
 		}
 
-		if len(line) > 0 {
-			break
+		known := false
+		for _, answerFlag := range initAnswerFlags {
+			known = known || answerFlag.name == flag
+		}
+		if !known {
+			return fmt.Errorf("%s: unknown answer \"%s\"", path, key)
 		}
-	}
 
-	if strings.ToLower(line)[0] == 'y' {
-		return true
+		answer, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %s must be a string", path, key)
+		}
+
+		if _, ok := initGivenAnswers[flag]; !ok {
+			initGivenAnswers[flag] = answer
+		}
 	}
 
-	return false
+	return nil
 }
 
-func askString(rl *readline.Instance, question string, def *string) string {
-	fmt.Println(question)
+// initPrompter asks init's questions, taking answers that were given up
+// front instead of asking them.  Without a terminal to ask on, each
+// question with no answer is recorded in missing, so they can all be
+// reported at once.
+type initPrompter struct {
+	rl      *readline.Instance // nil when stdin is not a terminal
+	missing []string
+	err     error // the user ended input; nothing more is asked
+}
+
+func (p *initPrompter) readLine(def *string) string {
+	if p.err != nil {
+		return ""
+	}
 
 	var line string
-	var err error
 	if def == nil {
-		line, err = rl.Readline()
+		line, p.err = p.rl.Readline()
 	} else {
-		line, err = rl.ReadlineWithDefault(*def)
-	}
-	if err != nil {
-		panic(err)
+		line, p.err = p.rl.ReadlineWithDefault(*def)
 	}
 
 	return line
 }
 
-func Init() {
-	rl, err := readline.New("> ")
-	if err != nil {
-		panic(err)
+// askYesNo asks a yes or no question, which --yes answers with yes.
+// hint says how to answer it when there's no terminal to ask on.
+func (p *initPrompter) askYesNo(question, hint string) bool {
+	if *initYes {
+		return true
 	}
-	defer rl.Close()
 
-	fmt.Println("This initialization process asks a few questions about your project and generates a qtcdbg.toml file.")
-	fmt.Println("This toml file is then used on subsequent launches.\n\n")
+	if p.rl == nil {
+		p.addMissing(hint)
+		return false
+	}
 
-	//
-	// create file befoer asking the user a ton of questions in case there's an error
-	//
-	if _, err := os.Stat(defaultConfig()); err == nil {
-		if !askYesNo(rl, defaultConfig()+" already exists.  Overwrite your config?") {
-			fmt.Println("No changes made.")
-			os.Exit(1)
+	fmt.Println(question + " (y/N)")
+
+	var line string
+	for p.err == nil && len(line) == 0 {
+		line = p.readLine(nil)
+	}
+
+	return len(line) > 0 && strings.ToLower(line)[0] == 'y'
+}
+
+// askString asks the question answered by flag.  --yes takes def, if
+// there is one.
+func (p *initPrompter) askString(flag, question string, def *string) string {
+	if answer, ok := initGivenAnswers[flag]; ok {
+		return answer
+	}
+
+	if *initYes && def != nil {
+		return *def
+	}
+
+	if p.rl == nil {
+		p.addMissing("--" + flag)
+		if def != nil {
+			return *def
 		}
+		return ""
 	}
-	outFile, err := os.Create(defaultConfig())
-	if err != nil {
-		panic(err)
+
+	fmt.Println(question)
+	return p.readLine(def)
+}
+
+// askOptional asks a question that can be left unanswered, which it is
+// with def, with --yes or without a terminal
+func (p *initPrompter) askOptional(flag, question, def string) string {
+	if answer, ok := initGivenAnswers[flag]; ok {
+		return answer
 	}
-	defer outFile.Close()
 
-	//
-	// ask questions
-	//
-	var cfg TomlConfig
+	if *initYes || p.rl == nil {
+		return def
+	}
+
+	fmt.Println(question)
+	return p.readLine(&def)
+}
+
+// askChoice asks for one of options by number, or 0 for none of them.
+// --yes takes def, and without a terminal none are chosen.
+func (p *initPrompter) askChoice(question string, options []string, def int) int {
+	if *initYes {
+		return def
+	}
+
+	if p.rl == nil {
+		return 0
+	}
+
+	fmt.Printf("%s (default %d)\n", question, def)
+	for i, option := range options {
+		fmt.Printf("  %d. %s\n", i+1, option)
+	}
+	fmt.Println("  0. none of these")
+
+	for p.err == nil {
+		line := strings.TrimSpace(p.readLine(nil))
+		if line == "" && p.err == nil {
+			return def
+		}
+
+		choice, err := strconv.Atoi(line)
+		if err == nil && choice >= 0 && choice <= len(options) {
+			return choice
+		}
+
+		if p.err == nil {
+			fmt.Printf("Enter a number from 0 to %d.\n", len(options))
+		}
+	}
+
+	return 0
+}
+
+func (p *initPrompter) addMissing(hint string) {
+	if !slices.Contains(p.missing, hint) {
+		p.missing = append(p.missing, hint)
+	}
+}
+
+// whether any of the flags were answered up front
+func isAnyAnswerGiven(flags ...string) bool {
+	for _, flag := range flags {
+		if _, ok := initGivenAnswers[flag]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// initSession holds the config init is filling in and what it found
+// in the project
+type initSession struct {
+	p      *initPrompter
+	cfg    TomlConfig
+	root   string // absolute path of the project root
+	builds []detectedBuild
+	build  detectedBuild // the detected build that was chosen, if any
+}
 
-	// get relative root
-	if askYesNo(rl, "Did you just launch qtcdbg from the project repo root?") == false {
-		fmt.Println("Re-run \"qtcdbg init\" from your project root")
-		os.Exit(1)
+// askRoot finds the project root at or above configDir, and
+// points relative_root at it from configDir.  When there is no marker
+// or version control directory to find it by, it asks whether the
+// current directory is the root.
+func (s *initSession) askRoot(configDir string) bool {
+	root, ok := findProjectRoot(configDir, *initRootMarkers)
+	if ok {
+		fmt.Printf("Found project root %s\n", root)
+	} else {
+		if !s.p.askYesNo("Could not find a version control directory.  Did you just launch qtcdbg from the project root?",
+			"--yes, to confirm the current directory is the project root") && s.p.rl != nil {
+			if s.p.err == nil {
+				fmt.Println("Re-run \"qtcdbg init\" from your project root, or mark it with --root-marker")
+			}
+			return false
+		}
+
+		var err error
+		root, err = os.Getwd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return false
+		}
 	}
 
-	workingDir, err := os.Getwd()
-	cfg.Project.RelativeRoot = workingDir
+	s.root = root
+
+	var err error
+	s.cfg.Project.RelativeRoot, err = getRelativeRoot(configDir, s.root)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
 	}
 
-	defaultProjectName := filepath.Base(workingDir)
-	cfg.Project.Name = askString(rl, "What is the name of your project?", &defaultProjectName)
+	return true
+}
 
-	if !askYesNo(rl, "When you launch your compiled program, do you do it from the project root?") {
-		cfg.Run.WorkingDir = askString(rl, "What is the working directory, relative to project root, that the debugged executable runs in? (eg: bin/)", nil)
+func (s *initSession) askName() {
+	defaultProjectName := filepath.Base(s.root)
+	s.cfg.Project.Name = s.p.askString("name", "What is the name of your project?", &defaultProjectName)
+}
+
+func (s *initSession) askRunDir() {
+	// a given run dir answers whether it runs from the root, and --yes
+	// takes running from the root as the default
+	runFromRoot := !isAnyAnswerGiven("run-dir")
+	if runFromRoot && !*initYes {
+		runFromRoot = s.p.askYesNo("When you launch your compiled program, do you do it from the project root?", "--run-dir")
+	}
+	if !runFromRoot {
+		s.cfg.Run.WorkingDir = s.p.askString("run-dir", "What is the working directory, relative to project root, that the debugged executable runs in? (eg: bin/)", nil)
 	} else {
-		cfg.Run.WorkingDir = "." + Separator
+		s.cfg.Run.WorkingDir = "." + Separator
+	}
+}
+
+// getUnbuiltExecutables lists the executables the meson builds that
+// were found will build, but haven't yet
+func (s *initSession) getUnbuiltExecutables(found []executableCandidate) []executableCandidate {
+	seen := make(map[string]bool)
+	for _, exe := range found {
+		seen[exe.path] = true
+	}
+
+	var unbuilt []executableCandidate
+	for _, build := range s.builds {
+		if build.system != "Meson" {
+			continue
+		}
+
+		project, err := readMesonProject(filepath.Join(s.root, build.configuredDir))
+		if err != nil {
+			if *debug {
+				fmt.Printf("Could not introspect %s: %v\n", build.configuredDir, err)
+			}
+			continue
+		}
+
+		for _, exe := range project.executables {
+			path, err := filepath.Rel(s.root, exe.path)
+			if err != nil || seen[filepath.ToSlash(path)] || isFile(exe.path) {
+				continue
+			}
+			seen[filepath.ToSlash(path)] = true
+
+			unbuilt = append(unbuilt, executableCandidate{
+				path:      filepath.ToSlash(path),
+				hasDwarf:  project.debug,
+				nameScore: getNameScore(filepath.Base(path), s.cfg.Project.Name),
+			})
+		}
 	}
 
-	candidateExecutablePath := cfg.Run.WorkingDir + Separator + cfg.Project.Name
-	if runtime.GOOS == "windows" {
-		candidateExecutablePath += ".exe"
+	return unbuilt
+}
+
+func (s *initSession) askExecutable() {
+	previous := s.cfg.Run.ExecutablePath
+	s.cfg.Run.ExecutablePath = ""
+
+	// offer the executables already built, or the auto setting, which
+	// finds the newest one each launch
+	if _, ok := initGivenAnswers["exe"]; !ok {
+		searchDirs := []string{s.cfg.Run.WorkingDir}
+		for _, build := range s.builds {
+			searchDirs = append(searchDirs, build.workingDir, build.compileCommandsDir)
+		}
+
+		executables := findExecutables(s.root, getExecutableSearchDirs(s.root, searchDirs...), s.cfg.Project.Name)
+		executables = append(executables, s.getUnbuiltExecutables(executables)...)
+		if len(executables) != 0 {
+			options := make([]string, 0, len(executables)+1)
+			for _, exe := range executables {
+				options = append(options, exe.String())
+			}
+			options = append(options, "auto: the newest of these each time qtcdbg launches")
+
+			choice := s.p.askChoice("Which executable would you like to debug?", options, 1)
+			switch {
+			case choice == len(options):
+				s.cfg.Run.ExecutablePath = autoExecutable
+			case choice != 0:
+				s.cfg.Run.ExecutablePath = executables[choice-1].path
+			}
+		}
+	}
+
+	if s.cfg.Run.ExecutablePath == "" {
+		candidateExecutablePath := previous
+		if candidateExecutablePath == "" {
+			candidateExecutablePath = filepath.Join(s.cfg.Run.WorkingDir, s.cfg.Project.Name)
+			if runtime.GOOS == "windows" {
+				candidateExecutablePath += ".exe"
+			}
+		}
+		s.cfg.Run.ExecutablePath = s.p.askString("exe", "What is the path and filename of the debug executable?", &candidateExecutablePath)
+	}
+}
+
+func (s *initSession) askRunArguments() {
+	s.cfg.Run.Arguments = commandArgs{line: s.p.askOptional("args", "Which command line arguments would you like to launch it with when debugging?", "")}
+}
+
+func (s *initSession) askBuild() {
+	// building is set up when a build answer is given.  Otherwise the
+	// build systems found in the project are offered, or it is asked.
+	// Without a terminal it is not set up, and --yes sets up the first
+	// build system found.
+	buildInQtCreator := isAnyAnswerGiven("build-cmd", "build-dir", "build-args")
+	if len(s.builds) != 0 {
+		options := make([]string, len(s.builds))
+		for i := range s.builds {
+			options[i] = s.builds[i].String()
+		}
+
+		choice := 1
+		if !buildInQtCreator {
+			choice = s.p.askChoice("Which of these would you like to build your program with inside QtCreator?", options, 1)
+			buildInQtCreator = choice != 0
+		}
+
+		if choice != 0 {
+			s.build = s.builds[choice-1]
+		}
+	} else if !buildInQtCreator && !*initYes && s.p.rl != nil {
+		buildInQtCreator = s.p.askYesNo("Would you like to be able to build your program inside QtCreator, too?", "")
 	}
-	cfg.Run.ExecutablePath = askString(rl, "What is the path and filename of the debug executable?", &candidateExecutablePath)
 
-	cfg.Run.Arguments = askString(rl, "Which command line arguments would you like to launch it with when debugging?", nil)
-	cfg.Run.RunInTerminal = true
+	if buildInQtCreator {
+		var defaultBuildDir, defaultBuildCmd *string
+		if s.build.command != "" {
+			defaultBuildDir = &s.build.workingDir
+			defaultBuildCmd = &s.build.command
+		}
 
-	if askYesNo(rl, "Would you like to be able to build your program inside QtCreator, too?") {
-		cfg.Build.WorkingDir = askString(rl, "What is the directory, relative to project root, that your build command runs in? (eg: build/)", nil)
-		cfg.Build.Command = askString(rl, "What is the build command?", nil)
-		cfg.Build.Arguments = askString(rl, "What are the build command arguments?", nil)
+		s.cfg.Build.WorkingDir = s.p.askString("build-dir", "What is the directory, relative to project root, that your build command runs in? (eg: build/)", defaultBuildDir)
+		s.cfg.Build.Command = s.p.askString("build-cmd", "What is the build command?", defaultBuildCmd)
+		s.cfg.Build.Arguments = commandArgs{line: s.p.askOptional("build-args", "What are the build command arguments?", s.build.arguments)}
+
+		// a configured build dir can describe the project
+		if s.build.configuredDir != "" {
+			configuredDir := filepath.ToSlash(s.build.configuredDir) + "/"
+			switch s.build.system {
+			case "CMake":
+				s.cfg.CMake.BuildDir = configuredDir
+			case "Meson":
+				s.cfg.Meson.BuildDir = configuredDir
+			}
+		}
 	} else {
 		// just launch qtcdbg.exe --help to get it to dummy return 0
-		cfg.Build.WorkingDir, _ = filepath.Abs(filepath.Dir(os.Args[0]))
-		cfg.Build.Command = os.Args[0]
-		cfg.Build.Arguments = "--help"
+		s.cfg.Build.WorkingDir, _ = filepath.Abs(filepath.Dir(os.Args[0]))
+		s.cfg.Build.Command = os.Args[0]
+		s.cfg.Build.Arguments = commandArgs{line: "--help"}
 	}
+}
 
-	//
-	// escape slashes in paths
-	//
-	escape(&cfg.Project.RelativeRoot)
-	escape(&cfg.Run.WorkingDir)
-	escape(&cfg.Build.WorkingDir)
-	escape(&cfg.Run.ExecutablePath)
-	escape(&cfg.Build.Command)
+// findCompileCommands sets compile_commands.dir to the compile database
+// of the chosen build or, when none was chosen, the first one found.
+// It returns false if there is none.
+func (s *initSession) findCompileCommands() bool {
+	dir := s.build.compileCommandsDir
+	for _, detected := range s.builds {
+		if s.build.command == "" && dir == "" {
+			dir = detected.compileCommandsDir
+		}
+	}
+
+	if dir == "" {
+		return false
+	}
+
+	s.cfg.CompileCommands.Dir = filepath.ToSlash(dir) + "/"
+	return true
+}
+
+// isAnswered reports, once every question has been asked, whether they
+// were all answered.  If not, why is reported.
+func (p *initPrompter) isAnswered() bool {
+	if p.err != nil {
+		fmt.Println("No changes made.")
+		return false
+	}
+
+	if len(p.missing) != 0 {
+		fmt.Fprintf(os.Stderr, "stdin is not a terminal, so qtcdbg init can't ask for these answers.  Pass them as flags or in --answers:\n")
+		for _, missing := range p.missing {
+			fmt.Fprintf(os.Stderr, "  %s\n", missing)
+		}
+		return false
+	}
+
+	return true
+}
+
+// Init asks questions about the project and writes a config file from
+// the answers.  Answers can be given up front with flags or an answers
+// file, so it can run without a terminal.  With --update, it asks only
+// about what is missing or invalid in an existing config.
+func Init() int {
+	if *initAnswersPath != "" {
+		err := readInitAnswersFile(*initAnswersPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	p := &initPrompter{}
+	if readline.IsTerminal(int(os.Stdin.Fd())) {
+		var err error
+		p.rl, err = readline.New("> ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		defer p.rl.Close()
+	}
+
+	if *initUpdate {
+		return updateConfig(p)
+	}
+
+	return createConfig(p)
+}
+
+func createConfig(p *initPrompter) int {
+	if p.rl != nil {
+		fmt.Println("This initialization process asks a few questions about your project and generates a qtcdbg.toml file.")
+		fmt.Print("This toml file is then used on subsequent launches.\n\n\n")
+	}
+
+	if _, err := os.Stat(defaultConfig()); err == nil {
+		if !p.askYesNo(defaultConfig()+" already exists.  Overwrite your config?  \"qtcdbg init --update\" fills in what it is missing instead.",
+			"--yes, to overwrite "+defaultConfig()) && p.rl != nil {
+			fmt.Println("No changes made.")
+			return 1
+		}
+	}
 
 	//
-	// render template
+	// ask questions
 	//
-	tmpl, err := template.New("config").Parse(tmplToml)
+	s := &initSession{p: p}
+
+	// the config is written to the current directory
+	if !s.askRoot(".") {
+		if p.err != nil {
+			fmt.Println("No changes made.")
+		}
+		return 1
+	}
+
+	s.askName()
+	s.askRunDir()
+	s.builds = detectBuildSystems(s.root)
+	s.askExecutable()
+	s.askRunArguments()
+	s.cfg.Run.RunInTerminal = true
+	s.askBuild()
+
+	if !s.findCompileCommands() {
+		s.cfg.CompileCommands.Dir = "src/"
+	}
+
+	if !p.isAnswered() {
+		return 1
+	}
+
+	config, err := renderInitConfig(&s.cfg)
 	if err != nil {
-		panic(err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
 
-	err = tmpl.Execute(outFile, cfg)
+	// written only once every question is answered, so a failed or
+	// cancelled init leaves any existing config alone
+	err = writeFileAtomic(defaultConfig(), config)
 	if err != nil {
-		fmt.Printf("config:\n%+v\n", tmplToml)
-		panic(err)
+		fmt.Fprintf(os.Stderr, "Could not write config: %v\n", err)
+		return 1
 	}
 
-	fmt.Printf("%s was successfully written with your preferences!", defaultConfig())
-	fmt.Println("Feel free to check this file in to source control. It should work for all users.\n")
+	fmt.Printf("%s was successfully written with your preferences!\n", defaultConfig())
+
+	if s.cfg.CMake.BuildDir != "" {
+		err = writeCMakeQuery(filepath.Join(s.root, s.cfg.CMake.BuildDir))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the CMake File API query: %v\n", err)
+		} else {
+			fmt.Printf("Run \"cmake %s\" once more so qtcdbg can read the targets of the build.\n", s.cfg.CMake.BuildDir)
+		}
+	}
+	fmt.Print("Feel free to check this file in to source control. It should work for all users.\n\n")
+	fmt.Printf("Personal settings can go in %s, which should not be checked in.\n\n", getLocalConfigPath(defaultConfig()))
 	fmt.Println("There are a couple options you may want to edit, even after this init procedure:")
 	fmt.Println(" - config_defines lets you specify defines that alter QtCreator's source gray-out")
-	fmt.Println(" - run_in_terminal can disable the terminal pop-up when debugging if it is not needed\n")
+	fmt.Print(" - run_in_terminal can disable the terminal pop-up when debugging if it is not needed\n\n")
 	fmt.Println("Running qtcdbg without arguments is usually enough to launch QtCreator at this point.")
-	os.Exit(0)
+
+	return 0
+}
+
+// the keys init --update can fill in, and the answer flags that set
+// them.  Optional keys are only asked about when answered with a flag.
+var initUpdateKeys = []struct {
+	key      string
+	flags    []string
+	optional bool
+}{
+	{"project.relative_root", nil, false},
+	{"project.name", []string{"name"}, false},
+	{"run.working_dir", []string{"run-dir"}, false},
+	{"run.executable_path", []string{"exe"}, false},
+	{"run.arguments", []string{"args"}, true},
+	{"build.command", []string{"build-cmd", "build-dir", "build-args"}, false},
+	{"build.working_dir", []string{"build-cmd", "build-dir", "build-args"}, false},
+	{"compile_commands.dir", nil, false},
+}
+
+// updateConfig asks only the questions for keys that are missing from
+// the config, or that qtcdbg check finds a problem with, and for those
+// answered with flags.  The answers are set in the file in place, so
+// its comments and any other tables are kept.
+func updateConfig(p *initPrompter) int {
+	path, err := findConfig("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		return 1
+	}
+
+	if isJsonConfig(path) {
+		fmt.Fprintf(os.Stderr, "%s: init --update only edits TOML configs\n", path)
+		return 1
+	}
+
+	original, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cfg, err := loadConfigLayers(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	cfg.Misc.ProjectRoot = getProjectRoot(&cfg)
+
+	problemKeys := make(map[string]bool)
+	for _, problem := range validateConfig(&cfg) {
+		problemKeys[problem.key] = true
+	}
+
+	var mainRaw map[string]interface{}
+	for _, layer := range cfg.Misc.Layers {
+		if layer.path == path {
+			mainRaw = layer.raw
+			break
+		}
+	}
+
+	// answers are written to this file, so a problem with a key another
+	// file sets has to be fixed there.  A problem with a value that has
+	// variables may be with what they expand to here, so it isn't
+	// replaced with an answer that drops them.
+	needed := make(map[string]bool)
+	for _, updateKey := range initUpdateKeys {
+		key := updateKey.key
+		source, isSet := cfg.Misc.Sources[key]
+		rawValue, _ := getTableValue(mainRaw, key)
+
+		switch {
+		case isAnyAnswerGiven(updateKey.flags...):
+			needed[key] = true
+		case !isSet && !updateKey.optional:
+			needed[key] = true
+		case !problemKeys[key]:
+			// nothing to fix
+		case source != path:
+			fmt.Printf("%s is set in %s; fix it there.\n", key, source)
+		case hasConfigVariables(rawValue):
+			fmt.Printf("%s is set with variables; fix it by hand.\n", key)
+		default:
+			needed[key] = true
+		}
+	}
+
+	s := &initSession{p: p, cfg: cfg, root: cfg.Misc.ProjectRoot}
+	var updated []string
+
+	if needed["project.relative_root"] {
+		if !s.askRoot(filepath.Dir(path)) {
+			if p.err != nil {
+				fmt.Println("No changes made.")
+			}
+			return 1
+		}
+		updated = append(updated, "project.relative_root")
+	}
+
+	if needed["project.name"] {
+		s.askName()
+		updated = append(updated, "project.name")
+	}
+
+	if needed["run.working_dir"] {
+		s.askRunDir()
+		updated = append(updated, "run.working_dir")
+	}
+
+	s.builds = detectBuildSystems(s.root)
+
+	if needed["run.executable_path"] {
+		s.askExecutable()
+		updated = append(updated, "run.executable_path")
+	}
+
+	if needed["run.arguments"] {
+		s.askRunArguments()
+		updated = append(updated, "run.arguments")
+	}
+
+	if needed["build.command"] || needed["build.working_dir"] {
+		s.askBuild()
+		updated = append(updated, "build.working_dir", "build.command", "build.arguments")
+	}
+
+	if needed["compile_commands.dir"] && s.findCompileCommands() {
+		updated = append(updated, "compile_commands.dir")
+	}
+
+	if !p.isAnswered() {
+		return 1
+	}
+
+	if len(updated) == 0 {
+		fmt.Printf("%s has everything init asks about.  No changes made.\n", path)
+		return 0
+	}
+
+	values := map[string]interface{}{
+		"project.relative_root": s.cfg.Project.RelativeRoot,
+		"project.name":          s.cfg.Project.Name,
+		"run.working_dir":       s.cfg.Run.WorkingDir,
+		"run.executable_path":   s.cfg.Run.ExecutablePath,
+		"run.arguments":         s.cfg.Run.Arguments,
+		"build.working_dir":     s.cfg.Build.WorkingDir,
+		"build.command":         s.cfg.Build.Command,
+		"build.arguments":       s.cfg.Build.Arguments,
+		"compile_commands.dir":  s.cfg.CompileCommands.Dir,
+	}
+
+	doc := parseTomlConfigDocument(path, original)
+	for _, key := range updated {
+		encoded, err := encodeTomlValue(values[key])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", key, err)
+			return 1
+		}
+
+		table, name, _ := strings.Cut(key, ".")
+		doc.setKey(table, name, encoded)
+	}
+
+	// written only once every question is answered
+	err = writeFileAtomic(path, doc.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write config: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Updated %s in %s.\n", strings.Join(updated, ", "), path)
+
+	for _, key := range updated {
+		if source, ok := cfg.Misc.Sources[key]; ok && source != path {
+			fmt.Fprintf(os.Stderr, "warning: %s is also set in %s, which overrides %s\n", key, source, path)
+		}
+	}
+
+	return 0
 }
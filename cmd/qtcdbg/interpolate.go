@@ -0,0 +1,228 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// matches ${name}, and $$ which escapes a dollar sign
+var reVariable = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+// interpolator expands variables in the string values of a merged
+// config.  A variable is one of:
+//
+//	${env:VAR}        environment variable VAR
+//	${project_root}   absolute path of the project root
+//	${config_dir}     absolute path of the directory holding the config
+//	${os}, ${arch}    runtime.GOOS and runtime.GOARCH
+//	${table.key}      the value of another key, like ${build.working_dir}
+//	${name}           a user defined variable from the [vars] table
+//
+// Undefined variables and reference cycles are reported as problems.
+type interpolator struct {
+	cfg       *TomlConfig
+	merged    map[string]interface{}
+	builtins  map[string]string
+	expanded  map[string]string // dotted key to its expanded value
+	resolving map[string]bool   // dotted keys being expanded, to catch cycles
+	problems  []configProblem
+}
+
+func newInterpolator(cfg *TomlConfig, merged map[string]interface{}) *interpolator {
+	in := &interpolator{
+		cfg:       cfg,
+		merged:    merged,
+		expanded:  make(map[string]string),
+		resolving: make(map[string]bool),
+	}
+
+	configDir, _ := filepath.Abs(filepath.Dir(cfg.Misc.cfgPath))
+	in.builtins = map[string]string{
+		"config_dir": configDir,
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+	}
+
+	return in
+}
+
+func (in *interpolator) report(key, format string, args ...interface{}) {
+	path, line := locateConfigKey(in.cfg, key)
+	in.problems = append(in.problems, configProblem{
+		path:    path,
+		line:    line,
+		key:     key,
+		message: fmt.Sprintf(format, args...),
+		isError: true,
+	})
+}
+
+// find the raw value at a dotted key in the merged config
+func (in *interpolator) getRaw(key string) (interface{}, bool) {
+	return getTableValue(in.merged, key)
+}
+
+// expandKey returns the expanded string value of a dotted key
+func (in *interpolator) expandKey(key string) (string, bool) {
+	if value, ok := in.expanded[key]; ok {
+		return value, true
+	}
+
+	raw, ok := in.getRaw(key)
+	if !ok {
+		return "", false
+	}
+
+	switch raw := raw.(type) {
+	case string:
+		if in.resolving[key] {
+			in.report(key, "variable reference cycle")
+			return "", true
+		}
+
+		in.resolving[key] = true
+		value := in.expand(raw, key)
+		delete(in.resolving, key)
+
+		in.expanded[key] = value
+		return value, true
+
+	case map[string]interface{}, []interface{}, []map[string]interface{}:
+		return "", false
+
+	default:
+		return fmt.Sprint(raw), true
+	}
+}
+
+// lookup finds the value of one ${name} used in key
+func (in *interpolator) lookup(name, key string) (string, bool) {
+	if envName, ok := strings.CutPrefix(name, "env:"); ok {
+		return os.LookupEnv(envName)
+	}
+
+	if name == "project_root" {
+		return in.getProjectRoot(key)
+	}
+
+	if value, ok := in.builtins[name]; ok {
+		return value, true
+	}
+
+	if strings.Contains(name, ".") {
+		return in.expandKey(name)
+	}
+
+	return in.expandKey("vars." + name)
+}
+
+// the project root depends on relative_root, which may itself hold
+// variables
+func (in *interpolator) getProjectRoot(key string) (string, bool) {
+	relativeRoot, ok := in.expandKey("project.relative_root")
+	if !ok {
+		relativeRoot = ""
+	}
+
+	projectRoot, err := filepath.Abs(filepath.Join(in.builtins["config_dir"], relativeRoot))
+	if err != nil {
+		in.report(key, "%v", err)
+	}
+
+	return projectRoot, true
+}
+
+// expand every variable in s, the value of key
+func (in *interpolator) expand(s, key string) string {
+	return reVariable.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+
+		name := strings.TrimSpace(match[2 : len(match)-1])
+		value, ok := in.lookup(name, key)
+		if !ok {
+			in.report(key, "undefined variable ${%s}", name)
+			return match
+		}
+
+		return value
+	})
+}
+
+// expandTable expands every string, including strings in arrays, in a
+// table of the merged config
+func (in *interpolator) expandTable(table map[string]interface{}, prefix string) {
+	for name, value := range table {
+		key := prefix + name
+
+		switch value := value.(type) {
+		case string:
+			// strings in arrays of tables can't be referred to, so they
+			// are expanded where they are
+			expanded, ok := in.expandKey(key)
+			if !ok {
+				expanded = in.expand(value, key)
+			}
+			table[name] = expanded
+
+		case []interface{}:
+			for i, item := range value {
+				if s, ok := item.(string); ok {
+					value[i] = in.expand(s, key)
+				}
+			}
+
+		case map[string]interface{}:
+			in.expandTable(value, key+".")
+
+		case []map[string]interface{}:
+			for _, itemTable := range value {
+				in.expandTable(itemTable, key+".")
+			}
+		}
+	}
+}
+
+// hasConfigVariables reports whether a raw value, or a string in it if
+// it is an array, uses any ${name} variables
+func hasConfigVariables(value interface{}) bool {
+	isVariable := func(s string) bool {
+		for _, match := range reVariable.FindAllString(s, -1) {
+			if match != "$$" {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch value := value.(type) {
+	case string:
+		return isVariable(value)
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok && isVariable(s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// interpolateConfig expands the variables in a merged config in place,
+// returning the problems found
+func interpolateConfig(cfg *TomlConfig, merged map[string]interface{}) []configProblem {
+	in := newInterpolator(cfg, merged)
+	in.expandTable(merged, "")
+
+	return in.problems
+}
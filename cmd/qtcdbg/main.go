@@ -6,13 +6,17 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"gopkg.in/ini.v1"
+	"io/fs"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 
 	"os"
@@ -24,62 +28,258 @@ var (
 	app = kingpin.New("qtcdbg", "QtCreator debugger launcher")
 
 	// common
-	debug   = app.Flag("debug", "Verbose debug qtcdbg").Bool()
-	version = app.Flag("version", "Show version and exit").Short('v').Bool()
+	debug            = app.Flag("debug", "Verbose debug qtcdbg").Bool()
+	version          = app.Flag("version", "Show version and exit").Short('v').Bool()
+	globalConfigPath = app.Flag("config", "Path to config file, if not given to the command.  Defaults to $QTCDBG_CONFIG, then a search from the current directory").String()
 
 	// launch (default command)
 	launchCmd  = app.Command("launch", "Launch QtCreator as a debugger").Default()
 	configPath = launchCmd.Arg("config", "Path to config file").Default("").String()
 	noRun      = launchCmd.Flag("no-run", "Do not run QtCreator -- just generate project files").Bool()
-
-	// init
-	initCmd = app.Command("init", "Create toml config for your project")
+	launchOut  = launchCmd.Flag("out", "Directory to write generated project files to").String()
+	rescan     = launchCmd.Flag("rescan", "Ignore the cached project scan and walk the whole tree").Bool()
+	keepGoing  = launchCmd.Flag("keep-going", "Launch QtCreator even if some project files failed to generate").Bool()
+	watch      = launchCmd.Flag("watch", "Regenerate the project file lists as files are added and removed while QtCreator runs").Bool()
+
+	// generate
+	generateCmd        = app.Command("generate", "Generate QtCreator project files without launching QtCreator")
+	generateConfigPath = generateCmd.Arg("config", "Path to config file").Default("").String()
+	generateCheck      = generateCmd.Flag("check", "Exit non-zero if regenerating would change any generated file").Bool()
+	generateOut        = generateCmd.Flag("out", "Directory to write generated project files to").String()
+	generateRescan     = generateCmd.Flag("rescan", "Ignore the cached project scan and walk the whole tree").Bool()
+
+	// check
+	checkCmd        = app.Command("check", "Validate the config file and report any problems")
+	checkConfigPath = checkCmd.Arg("config", "Path to config file").Default("").String()
+
+	// migrate
+	migrateCmd        = app.Command("migrate", "Upgrade the config file to the current schema version")
+	migrateConfigPath = migrateCmd.Arg("config", "Path to config file").Default("").String()
+
+	// config
+	configCmd      = app.Command("config", "Inspect the config")
+	configShowCmd  = configCmd.Command("show", "Print the effective config, merged with its local override, and where each value came from")
+	configShowPath = configShowCmd.Arg("config", "Path to config file").Default("").String()
+
+	// import
+	importCmd        = app.Command("import", "Add run configurations from another tool's config to the qtcdbg config")
+	importVscodeCmd  = importCmd.Command("vscode", "Import cppdbg launch configurations from VS Code's launch.json")
+	importVscodePath = importVscodeCmd.Arg("path", "Path to launch.json, or the folder holding .vscode; the project root by default").Default("").String()
+
+	// export
+	exportCmd        = app.Command("export", "Write the run configuration for debuggers other than QtCreator")
+	exportConfigPath = exportCmd.Arg("config", "Path to config file").Default("").String()
+	exportFormat     = exportCmd.Flag("format", "vscode for a launch.json, gdbinit for a gdb command file, or lldbinit for an lldb command file").Required().Enum("vscode", "gdbinit", "lldbinit")
+	exportRun        = exportCmd.Flag("run", "Name of the [[runs]] entry to export instead of [run].  vscode exports them all when not given").String()
+	exportOut        = exportCmd.Flag("out", "File to write, instead of standard output").Short('o').String()
+
+	// schema
+	schemaCmd = app.Command("schema", "Print the JSON Schema of the config file, for editors to validate it with")
+
+	// init, with a flag for each question added in init.go
+	initCmd         = app.Command("init", "Create toml config for your project")
+	initYes         = initCmd.Flag("yes", "Answer yes to questions, and take the default for any answer not given").Bool()
+	initUpdate      = initCmd.Flag("update", "Fill in what is missing or invalid in the existing config, keeping the rest of it").Bool()
+	initRootMarkers = initCmd.Flag("root-marker", "File or directory that marks the project root, looked for before the version control directories.  Can be repeated").Strings()
+	initAnswersPath = initCmd.Flag("answers", "TOML file of answers, keyed by flag name with underscores, like run_dir = \"bin/\"").String()
 )
 
 const VersionMajor = 1
 const VersionMinor = 1
 
+// the config file names looked for, in order of preference
+func defaultConfigNames() []string {
+	base := "qtcdbg." + runtime.GOOS
+	return []string{base + ".toml", base + ".json"}
+}
+
 func defaultConfig() string {
-	return "qtcdbg." + runtime.GOOS + ".toml"
+	return defaultConfigNames()[0]
 }
 
-// find the user's config file
-func findConfig(userConfig string) (string, error) {
-	// user requested configs must be in the current dir
-	if userConfig != "" {
-		return userConfig, nil
+// how many directories below the current one findConfig looks in
+const configSearchDepth = 3
+
+// configs in dir with one of the default names, or a pyproject.toml
+// with a [tool.qtcdbg] table
+func getConfigCandidates(dir string) []string {
+	var candidates []string
+	for _, name := range defaultConfigNames() {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			candidates = append(candidates, path)
+		}
+	}
+
+	if path := filepath.Join(dir, "pyproject.toml"); hasPyprojectConfig(path) {
+		candidates = append(candidates, path)
 	}
 
-	// check for default filename in current directory
-	info, err := os.Stat(defaultConfig())
-	if !os.IsNotExist(err) && !info.IsDir() {
-		return defaultConfig(), nil
+	return candidates
+}
+
+// a single candidate is the config; more than one is an error
+func pickConfigCandidate(candidates []string) (string, error) {
+	if len(candidates) > 1 {
+		return "", fmt.Errorf("found %d configs, pick one by passing its path:\n  %s",
+			len(candidates), strings.Join(candidates, "\n  "))
 	}
 
-	// search for file recursively from the launch location
-	var foundPath *string
-	err = filepath.Walk(".",
-		func(path string, info os.FileInfo, err error) error {
+	return candidates[0], nil
+}
+
+// directories that version control keeps at the root of a checkout
+var vcsRootMarkers = []string{".git", ".hg", ".svn", ".jj"}
+
+// hasRootMarker reports whether dir contains one of markers.  .git is a
+// file in worktrees and submodules, so markers can be files.
+func hasRootMarker(dir string, markers []string) bool {
+	for _, marker := range markers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findProjectRoot looks in dir and each directory above it for one of
+// markers, then for a version control directory, returning the absolute
+// path of the nearest directory that has one
+func findProjectRoot(dir string, markers []string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for _, rootMarkers := range [][]string{markers, vcsRootMarkers} {
+		for search := dir; ; search = filepath.Dir(search) {
+			if hasRootMarker(search, rootMarkers) {
+				return search, true
+			}
+
+			if filepath.Dir(search) == search {
+				break
+			}
+		}
+	}
+
+	return "", false
+}
+
+// search the current directory and each one above it, stopping at the
+// root of the repository, the way git finds .git
+func findConfigUpward() ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := cwd
+	for {
+		candidates := getConfigCandidates(dir)
+		if len(candidates) != 0 {
+			// relative paths read better when the config is close by
+			for i, candidate := range candidates {
+				if rel, err := filepath.Rel(cwd, candidate); err == nil {
+					candidates[i] = rel
+				}
+			}
+			return candidates, nil
+		}
+
+		if hasRootMarker(dir, vcsRootMarkers) {
+			return nil, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// search the directories below the current one, up to
+// configSearchDepth deep, leaving out dot directories
+func findConfigDownward() ([]string, error) {
+	var candidates []string
+
+	err := filepath.WalkDir(".",
+		func(path string, entry fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
 
-			if !info.IsDir() && info.Name() == defaultConfig() {
-				foundPath = &path
+			if entry.IsDir() {
+				if path == "." {
+					return nil
+				}
+				if strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				if strings.Count(filepath.ToSlash(path), "/")+1 > configSearchDepth {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if slices.Contains(defaultConfigNames(), entry.Name()) ||
+				(entry.Name() == "pyproject.toml" && hasPyprojectConfig(path)) {
+				candidates = append(candidates, path)
 			}
 
 			return nil
 		})
-	if err != nil {
-		return "", nil
+
+	return candidates, err
+}
+
+// find the user's config file.  In order, it is:
+//
+//   - the path passed on the command line, or with --config
+//   - the path in the QTCDBG_CONFIG environment variable
+//   - in the current directory or one above it, up to the repository root
+//   - in a directory a few levels below the current one
+//
+// the config path the user gave, if any, from the command line or the
+// environment
+func getGivenConfig(userConfig string) string {
+	if userConfig == "" {
+		userConfig = *globalConfigPath
 	}
+	if userConfig == "" {
+		userConfig = os.Getenv("QTCDBG_CONFIG")
+	}
+
+	return userConfig
+}
 
-	if foundPath != nil {
-		fmt.Printf("Launching with found config %s\n", *foundPath)
-		return *foundPath, nil
+// Finding more than one config in the same place is an error.
+func findConfig(userConfig string) (string, error) {
+	userConfig = getGivenConfig(userConfig)
+	if userConfig != "" {
+		if _, err := os.Stat(userConfig); err != nil {
+			return "", err
+		}
+		return userConfig, nil
+	}
+
+	candidates, err := findConfigUpward()
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		candidates, err = findConfigDownward()
+		if err != nil {
+			return "", fmt.Errorf("searching for %s: %v", strings.Join(defaultConfigNames(), " or "), err)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", errors.New("Could not find " + strings.Join(defaultConfigNames(), " or "))
 	}
 
-	return "", errors.New("Could not find " + defaultConfig() + "\n")
+	return pickConfigCandidate(candidates)
 }
 
 func GetIniPath() (string, error) {
@@ -200,6 +400,14 @@ func GetKitId() (string, error) {
 }
 
 func handleGenerationError(err error) {
+	// GenerateProjectFiles joins one error per failed file
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, fileErr := range joined.Unwrap() {
+			fmt.Fprintf(os.Stderr, "Generation error: %v\n", fileErr)
+		}
+		return
+	}
+
 	fmt.Fprintf(os.Stderr, "Generation error: %v\n", err)
 }
 
@@ -249,10 +457,10 @@ func main() {
 }
 
 func RealMain() int {
-	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	switch command {
 	case initCmd.FullCommand():
-		Init()
-		return 0
+		return Init()
 	}
 
 	if *version {
@@ -260,33 +468,145 @@ func RealMain() int {
 		return 0
 	}
 
-	actualConfigPath, err := findConfig(*configPath)
+	switch command {
+	case generateCmd.FullCommand():
+		return Generate()
+	case checkCmd.FullCommand():
+		return Check()
+	case migrateCmd.FullCommand():
+		return Migrate()
+	case configShowCmd.FullCommand():
+		return ShowConfig()
+	case schemaCmd.FullCommand():
+		return Schema()
+	case importVscodeCmd.FullCommand():
+		return ImportVscode()
+	case exportCmd.FullCommand():
+		return Export()
+	}
+
+	return Launch()
+}
+
+// loadConfig finds and parses the config, and fills in the QtCreator
+// ids needed to generate the project files.  Warnings in the config are
+// printed as it loads.
+func loadConfig(userConfig, userOut string) (TomlConfig, error) {
+	actualConfigPath, err := findConfig(userConfig)
 	if err != nil {
-		fmt.Printf("Could not find config: %v", err)
-		return 0
+		return TomlConfig{}, fmt.Errorf("Could not find config: %v", err)
 	}
 
 	cfg, err := parseConfig(actualConfigPath)
 	if err != nil {
-		fmt.Printf("Error loading config: %v", err)
+		return cfg, fmt.Errorf("Error loading config: %v", err)
+	}
+
+	printConfigProblems(cfg.Misc.Problems)
+	if hasConfigErrors(cfg.Misc.Problems) {
+		return cfg, fmt.Errorf("Fix the errors in %s, or run \"qtcdbg check\" for details.", actualConfigPath)
+	}
+
+	// the reply is there the next time cmake configures the build dir
+	if cfg.CMake.BuildDir != "" {
+		err = writeCMakeQuery(resolveProjectPath(&cfg, cfg.CMake.BuildDir))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write the CMake File API query: %v\n", err)
+		}
+	}
+
+	cfg.Misc.OutputDir, err = resolveOutputDir(&cfg, userOut)
+	if err != nil {
+		return cfg, fmt.Errorf("Could not create output dir: %v", err)
+	}
+
+	environmentId, err := GetEnvironmentId()
+	if err != nil {
+		return cfg, fmt.Errorf("Did not find the environmentId in the QtCreator config file: %+v\n"+
+			"Running QtCreator once should generate this.", err)
+	}
+	cfg.Misc.EnvironmentId = environmentId
+
+	kitId, err := GetKitId()
+	if err != nil {
+		return cfg, fmt.Errorf("Did not find the kit id: %v", err)
+	}
+	cfg.Misc.KitId = kitId
+
+	if *debug {
+		fmt.Printf("EnvironmentId: %s\n", cfg.Misc.EnvironmentId)
+		fmt.Printf("KitId: %s\n", cfg.Misc.KitId)
+	}
+
+	return cfg, nil
+}
+
+// handleConfigError reports an error from loadConfig, returning the
+// exit code for it
+func handleConfigError(err error) int {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	return 1
+}
+
+// scan the project tree, letting ctrl-c cancel a long walk
+func scanWithInterrupt(cfg *TomlConfig, rescan bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	return ScanConfigProject(ctx, cfg, rescan)
+}
+
+// Generate writes the project files and leaves them in place, or with
+// --check, reports whether they are up to date.
+func Generate() int {
+	cfg, err := loadConfig(*generateConfigPath, *generateOut)
+	if err != nil {
+		return handleConfigError(err)
+	}
+
+	err = scanWithInterrupt(&cfg, *generateRescan)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to scan project: %v\n", err)
 		return 1
 	}
 
-	if cfg.CompileCommands.Override {
-		compileCommandsPath := cfg.CompileCommands.Dir + "/compile_commands.json"
-		_, err := os.Stat(compileCommandsPath)
-		if errors.Is(err, os.ErrNotExist) {
-			fmt.Printf("Compile commands file '%s' does not exist\n", compileCommandsPath)
+	if *generateCheck {
+		stale, err := CheckGeneratedFiles(&cfg)
+		if err != nil {
+			handleGenerationError(err)
+			return 1
+		}
+
+		for _, path := range stale {
+			fmt.Printf("%s is out of date\n", path)
+		}
+		if len(stale) != 0 {
 			return 1
 		}
+
+		return 0
 	}
 
-	environmentId, err := GetEnvironmentId()
+	err = GenerateProjectFiles(&cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Did not find the environmentId in the QtCreator config file: %+v\n", err)
-		fmt.Fprintf(os.Stderr, "Running QtCreator once should generate this.\n")
+		handleGenerationError(err)
 		return 1
 	}
+	fmt.Printf("Generated project files in %s\n", cfg.Misc.OutputDir)
+
+	return 0
+}
+
+func Launch() int {
+	cfg, err := loadConfig(*configPath, *launchOut)
+	if err != nil {
+		return handleConfigError(err)
+	}
+
+	// on stderr, so it stays out of anything read from stdout
+	if getGivenConfig(*configPath) == "" && filepath.Dir(cfg.Misc.cfgPath) != "." {
+		fmt.Fprintf(os.Stderr, "Launching with found config %s\n", cfg.Misc.cfgPath)
+	}
 
 	var clangdWrapperPath string
 	if cfg.CompileCommands.Override {
@@ -311,57 +631,49 @@ func RealMain() int {
 	defer cleanupPath(clangdWrapperPath)
 	defer SetClangdPath(cfg.Misc.OriginalClangdPath)
 
-	cfg.Misc.EnvironmentId = environmentId
-	kitId, err := GetKitId()
+	defer CleanupGeneratedFiles(&cfg, *noRun)
+
+	err = scanWithInterrupt(&cfg, *rescan)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Did not find the kit id.\n")
+		fmt.Fprintf(os.Stderr, "Failed to scan project: %v\n", err)
 		return 1
 	}
-	cfg.Misc.KitId = kitId
-
-	if *debug {
-		fmt.Printf("EnvironmentId: %s\n", cfg.Misc.EnvironmentId)
-		fmt.Printf("KitId: %s\n", cfg.Misc.KitId)
-	}
-
-	defer CleanupGeneratedFiles(&cfg, *noRun)
 
 	//
 	// begin generation
 	//
-	err = GenerateFlags(&cfg)
-	if err != nil {
-		handleGenerationError(err)
-		return 1
-	}
-
-	err = GenerateConfig(&cfg)
-	if err != nil {
-		handleGenerationError(err)
-	}
-
-	err = GenerateCreator(&cfg)
-	if err != nil {
-		handleGenerationError(err)
-	}
-
-	err = GenerateFiles(&cfg)
+	err = GenerateProjectFiles(&cfg)
 	if err != nil {
 		handleGenerationError(err)
+		if !*keepGoing {
+			fmt.Fprintf(os.Stderr, "Not launching QtCreator.  Use --keep-going to launch anyway.\n")
+			return 1
+		}
 	}
 
-	err = GenerateIncludes(&cfg)
-	if err != nil {
-		handleGenerationError(err)
+	if *noRun {
+		fmt.Printf("Generated project files in %s\n", cfg.Misc.OutputDir)
+		return 0
 	}
 
-	err = GenerateCreatorUser(&cfg)
-	if err != nil {
-		handleGenerationError(err)
-	}
+	if *watch {
+		ctx, stopWatching := context.WithCancel(context.Background())
+		watchDone := make(chan struct{})
+		go func() {
+			defer close(watchDone)
 
-	if *noRun {
-		return 0
+			err := WatchProject(ctx, &cfg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Stopped watching project: %v\n", err)
+			}
+		}()
+
+		// generated files are cleaned up once the watcher is done
+		// rewriting them
+		defer func() {
+			stopWatching()
+			<-watchDone
+		}()
 	}
 
 	creatorPath := getGeneratorPath(&cfg, cfg.Project.Name+".creator")
@@ -0,0 +1,187 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// a mesonProject is what meson's introspection says about a configured
+// build dir
+type mesonProject struct {
+	executables []mesonExecutable
+	debug       bool // whether the build has debug info
+}
+
+type mesonExecutable struct {
+	name string
+	path string // absolute path of the executable, built or not
+}
+
+// the parts of meson's introspection qtcdbg reads.  See
+// https://mesonbuild.com/IDE-integration.html
+type mesonIntrospection struct {
+	Targets []struct {
+		Name     string   `json:"name"`
+		Type     string   `json:"type"`
+		Filename []string `json:"filename"`
+	} `json:"targets"`
+	BuildOptions []struct {
+		Name  string      `json:"name"`
+		Value interface{} `json:"value"`
+	} `json:"buildoptions"`
+}
+
+// introspectMeson reads the targets and build options of the build in
+// buildDir from the files meson leaves in meson-info, or, when they
+// are missing, by running meson introspect
+func introspectMeson(buildDir string) (*mesonIntrospection, error) {
+	var intro mesonIntrospection
+
+	infoDir := filepath.Join(buildDir, "meson-info")
+	targetsData, targetsErr := os.ReadFile(filepath.Join(infoDir, "intro-targets.json"))
+	optionsData, optionsErr := os.ReadFile(filepath.Join(infoDir, "intro-buildoptions.json"))
+	if targetsErr == nil && optionsErr == nil {
+		err := json.Unmarshal(targetsData, &intro.Targets)
+		if err != nil {
+			return nil, fmt.Errorf("intro-targets.json: %v", err)
+		}
+
+		err = json.Unmarshal(optionsData, &intro.BuildOptions)
+		if err != nil {
+			return nil, fmt.Errorf("intro-buildoptions.json: %v", err)
+		}
+
+		return &intro, nil
+	}
+
+	mesonPath, err := exec.LookPath("meson")
+	if err != nil {
+		return nil, fmt.Errorf("no meson-info in %s, and meson is not in the path to introspect it", buildDir)
+	}
+
+	if *debug {
+		fmt.Printf("Running %s introspect --targets --buildoptions %s\n", mesonPath, buildDir)
+	}
+
+	// with more than one section, meson prints an object keyed by
+	// section name
+	out, err := exec.Command(mesonPath, "introspect", "--targets", "--buildoptions", buildDir).Output()
+	if err != nil {
+		return nil, fmt.Errorf("meson introspect: %v", err)
+	}
+
+	err = json.Unmarshal(out, &intro)
+	if err != nil {
+		return nil, fmt.Errorf("meson introspect: %v", err)
+	}
+
+	return &intro, nil
+}
+
+// readMesonProject introspects the build in buildDir
+func readMesonProject(buildDir string) (*mesonProject, error) {
+	intro, err := introspectMeson(buildDir)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &mesonProject{}
+	for _, target := range intro.Targets {
+		if target.Type != "executable" || len(target.Filename) == 0 {
+			continue
+		}
+
+		path := target.Filename[0]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(buildDir, path)
+		}
+
+		project.executables = append(project.executables, mesonExecutable{
+			name: target.Name,
+			path: path,
+		})
+	}
+
+	for _, option := range intro.BuildOptions {
+		if option.Name == "debug" {
+			project.debug, _ = option.Value.(bool)
+		}
+	}
+
+	return project, nil
+}
+
+// applyMesonDefaults uses the build in [meson] build_dir for the build
+// command and compile_commands.dir when they are not set.  It runs
+// before validateConfig, so the defaults are checked like set values.
+func applyMesonDefaults(cfg *TomlConfig) {
+	buildDir := resolveProjectPath(cfg, cfg.Meson.BuildDir)
+
+	if cfg.Build.Command == "" {
+		cfg.Build.WorkingDir = cfg.Misc.ProjectRoot
+		cfg.Build.Command = "meson"
+		cfg.Build.Arguments = commandArgs{list: []string{"compile", "-C", buildDir}, isList: true}
+	}
+
+	if cfg.CompileCommands.Dir == "" {
+		cfg.CompileCommands.Dir = buildDir
+	}
+}
+
+// loadMesonProject introspects the build in [meson] build_dir, adding
+// its executables as run configs
+func loadMesonProject(cfg *TomlConfig) []configProblem {
+	report := func(format string, args ...interface{}) []configProblem {
+		path, line := locateConfigKey(cfg, "meson.build_dir")
+		return []configProblem{{
+			path:    path,
+			line:    line,
+			key:     "meson.build_dir",
+			message: fmt.Sprintf(format, args...),
+		}}
+	}
+
+	// a missing build dir is reported by validateConfig
+	buildDir := resolveProjectPath(cfg, cfg.Meson.BuildDir)
+	if info, err := os.Stat(buildDir); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	project, err := readMesonProject(buildDir)
+	if err != nil {
+		return report("%v", err)
+	}
+
+	var problems []configProblem
+	if !project.debug {
+		problems = report("the build in %s has no debug info; configure it with -Ddebug=true", cfg.Meson.BuildDir)
+	}
+
+	debugged := map[string]bool{resolveProjectPath(cfg, cfg.Run.ExecutablePath): true}
+	for _, run := range cfg.Runs {
+		debugged[resolveProjectPath(cfg, run.ExecutablePath)] = true
+	}
+
+	for _, exe := range project.executables {
+		if debugged[exe.path] {
+			continue
+		}
+		debugged[exe.path] = true
+
+		cfg.Runs = append(cfg.Runs, runConfig{
+			Name:           exe.name,
+			WorkingDir:     filepath.Dir(exe.path),
+			ExecutablePath: exe.path,
+			RunInTerminal:  cfg.Run.RunInTerminal,
+		})
+	}
+
+	return problems
+}
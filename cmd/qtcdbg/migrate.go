@@ -0,0 +1,274 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigSchemaVersion is the schema_version this qtcdbg writes and
+// reads natively.  Configs without a schema_version are version 0.
+// Bump it, and add a configMigration, whenever a key in TomlConfig is
+// renamed or changes meaning.
+const ConfigSchemaVersion = 2
+
+// a keyRename moves a value from one key to another in the same table
+type keyRename struct {
+	table string
+	from  string
+	to    string
+}
+
+// a pathRebase makes a relative path in a key relative to the project
+// root, where it was relative to the directory qtcdbg ran in.  That is
+// taken to be the directory holding the config.
+type pathRebase struct {
+	table string
+	key   string
+}
+
+// a configMigration upgrades a config from schema version-1 to version
+type configMigration struct {
+	version int
+	renames []keyRename
+	rebases []pathRebase
+}
+
+var configMigrations = []configMigration{
+	{
+		version: 1,
+		renames: []keyRename{
+			// qtcdbg init wrote this before it was checked
+			{"compile_commands", "path", "dir"},
+		},
+	},
+	{
+		version: 2,
+		rebases: []pathRebase{
+			// clangd was pointed at it from wherever qtcreator ran
+			{"compile_commands", "dir"},
+		},
+	},
+}
+
+// a configDocument is a config file that migrations can edit.  Both
+// tomlDocument and jsonDocument are.
+type configDocument interface {
+	findKey(table, key string) int
+	renameKey(table, from, to string) bool
+	getString(table, key string) (string, bool)
+	setString(table, key, value string) error
+}
+
+// read schema_version from a decoded config.  A file that doesn't set
+// it is version 0.
+func getSchemaVersion(raw map[string]interface{}) (version int, isSet bool, err error) {
+	value, isSet := raw["schema_version"]
+	if !isSet {
+		return 0, false, nil
+	}
+
+	n, ok := value.(int64)
+	if !ok {
+		return 0, true, fmt.Errorf("schema_version must be an integer")
+	}
+
+	return int(n), true, nil
+}
+
+// migrateConfig upgrades doc from version to ConfigSchemaVersion,
+// editing it in place so comments in a TOML file are kept.  Each
+// change is returned as a deprecation warning.  Migrations never add
+// or remove lines, so line numbers in a migrated TOML doc still match
+// the file.  inheritedRoot is the relative_root doc has when it doesn't
+// set one, from the file it overrides.
+func migrateConfig(doc configDocument, path string, version int, inheritedRoot string) ([]configProblem, error) {
+	if version > ConfigSchemaVersion {
+		return nil, fmt.Errorf("%s has schema_version %d, but this qtcdbg only understands up to %d.  Upgrade qtcdbg.",
+			path, version, ConfigSchemaVersion)
+	}
+
+	var problems []configProblem
+	deprecated := func(line int, key, format string, args ...interface{}) {
+		problems = append(problems, configProblem{
+			path:    path,
+			line:    line,
+			key:     key,
+			message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if version < ConfigSchemaVersion {
+		deprecated(0, "schema_version", "config is schema version %d, current is %d", version, ConfigSchemaVersion)
+	}
+
+	for _, migration := range configMigrations {
+		if migration.version <= version {
+			continue
+		}
+
+		for _, rename := range migration.renames {
+			from := doc.findKey(rename.table, rename.from)
+			if from == -1 {
+				continue
+			}
+
+			fromKey := rename.table + "." + rename.from
+			if doc.findKey(rename.table, rename.to) != -1 {
+				deprecated(from+1, fromKey, "is ignored because \"%s\" is also set", rename.to)
+				continue
+			}
+
+			doc.renameKey(rename.table, rename.from, rename.to)
+			deprecated(from+1, fromKey, "renamed to \"%s\" in schema version %d", rename.to, migration.version)
+		}
+
+		for _, rebase := range migration.rebases {
+			value, ok := doc.getString(rebase.table, rebase.key)
+			if !ok || value == "" || filepath.IsAbs(value) {
+				continue
+			}
+
+			line := doc.findKey(rebase.table, rebase.key) + 1
+			key := rebase.table + "." + rebase.key
+
+			relativeRoot, ok := doc.getString("project", "relative_root")
+			if !ok {
+				relativeRoot = inheritedRoot
+			}
+
+			if hasConfigVariables(value) || hasConfigVariables(relativeRoot) {
+				deprecated(line, key, "is relative to the project root from schema version %d; it uses variables, so check it by hand", migration.version)
+				continue
+			}
+
+			configDir, err := filepath.Abs(filepath.Dir(path))
+			if err != nil {
+				return nil, err
+			}
+
+			rebased, err := filepath.Rel(filepath.Join(configDir, relativeRoot), filepath.Join(configDir, value))
+			if err != nil {
+				return nil, err
+			}
+
+			rebased = filepath.ToSlash(rebased)
+			if strings.HasSuffix(value, "/") {
+				rebased += "/"
+			}
+			if filepath.Clean(rebased) == filepath.Clean(value) {
+				continue
+			}
+
+			err = doc.setString(rebase.table, rebase.key, rebased)
+			if err != nil {
+				return nil, err
+			}
+			deprecated(line, key, "changed from \"%s\" to \"%s\", since it is relative to the project root from schema version %d", value, rebased, migration.version)
+		}
+	}
+
+	return problems, nil
+}
+
+// Migrate rewrites the config file at the current schema version.  The
+// original is kept alongside it with a .bak extension.
+func Migrate() int {
+	actualConfigPath, err := findConfig(*migrateConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		return 1
+	}
+
+	original, err := os.ReadFile(actualConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	raw, err := decodeConfigData(actualConfigPath, original)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	version, _, err := getSchemaVersion(raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", actualConfigPath, err)
+		return 1
+	}
+
+	if version == ConfigSchemaVersion {
+		fmt.Printf("%s is already at schema version %d.\n", actualConfigPath, version)
+		return 0
+	}
+
+	// TOML is edited line by line to keep its comments.  JSON has none
+	// to keep, so it is written back out from the migrated map.
+	var doc configDocument
+	if isJsonConfig(actualConfigPath) {
+		doc = &jsonDocument{data: original, raw: raw}
+	} else {
+		doc = parseTomlConfigDocument(actualConfigPath, original)
+	}
+
+	problems, err := migrateConfig(doc, actualConfigPath, version, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var migrated []byte
+	switch doc := doc.(type) {
+	case *tomlDocument:
+		doc.setKey("", "schema_version", fmt.Sprintf("%d", ConfigSchemaVersion))
+		migrated = doc.Bytes()
+
+	case *jsonDocument:
+		doc.raw["schema_version"] = ConfigSchemaVersion
+
+		// decoding drops the editor's "$schema", but the file keeps it
+		var schema struct {
+			Schema string `json:"$schema"`
+		}
+		if json.Unmarshal(original, &schema) == nil && schema.Schema != "" {
+			doc.raw["$schema"] = schema.Schema
+		}
+
+		migrated, err = doc.Bytes()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	for _, problem := range problems {
+		if problem.key != "schema_version" {
+			fmt.Printf("%s:%d: %s: %s\n", problem.path, problem.line, problem.key, problem.message)
+		}
+	}
+
+	backupPath := actualConfigPath + ".bak"
+	err = os.WriteFile(backupPath, original, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not back up config: %v\n", err)
+		return 1
+	}
+
+	err = writeFileAtomic(actualConfigPath, migrated)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write config: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Migrated %s to schema version %d.  The original is in %s.\n",
+		actualConfigPath, ConfigSchemaVersion, backupPath)
+
+	return 0
+}
@@ -0,0 +1,538 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// projectScan is the result of walking the project root once.  It feeds
+// both the .files and .includes generators.  All paths are relative to
+// the project root and unsorted.
+type projectScan struct {
+	Files      []string
+	HeaderDirs []string
+	Dirs       []string
+}
+
+// scanCacheDir is what one directory held the last time it was read.
+// Names are of the unpruned entries only.  Links maps the names of
+// followed directory symlinks to their resolved targets.
+type scanCacheDir struct {
+	ModTime   int64 // unix nanoseconds
+	Files     []string
+	Dirs      []string
+	Links     map[string]string `json:",omitempty"`
+	HasHeader bool
+}
+
+// scanCache lets a scan skip reading directories whose mtime has not
+// changed since the last launch.  A directory's mtime changes whenever
+// an entry is added, removed or renamed in it, which is all the scan
+// cares about.
+type scanCache struct {
+	Root     string
+	PruneKey string
+	Dirs     map[string]scanCacheDir
+}
+
+// a pruneFunc reports whether an entry under the project root should be
+// left out of the scan.  Pruned directories are not descended into.
+type pruneFunc func(relPath string, d fs.DirEntry) bool
+
+// matchSkipDir reports whether a directory matches one of the
+// [generate] skip_dirs patterns.  A pattern without a slash matches a
+// directory name at any depth, like "node_modules".  A pattern with a
+// slash matches a path relative to the project root, like
+// "third_party/*/test".  Both use path.Match globbing.
+func matchSkipDir(patterns []string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	name := path.Base(relPath)
+
+	for _, pattern := range patterns {
+		subject := name
+		if strings.Contains(pattern, "/") {
+			subject = relPath
+			pattern = strings.Trim(pattern, "/")
+		}
+
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// read the submodule paths from the .gitmodules file in the project
+// root.  A project without one has no submodules.
+func getSubmodulePaths(projectRoot string) ([]string, error) {
+	gitmodulesPath := filepath.Join(projectRoot, ".gitmodules")
+	if _, err := os.Stat(gitmodulesPath); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	gitmodules, err := ini.Load(gitmodulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", gitmodulesPath, err)
+	}
+
+	var paths []string
+	for _, section := range gitmodules.Sections() {
+		if !strings.HasPrefix(section.Name(), "submodule ") {
+			continue
+		}
+
+		submodulePath := section.Key("path").String()
+		if submodulePath != "" {
+			paths = append(paths, path.Clean(filepath.ToSlash(submodulePath)))
+		}
+	}
+
+	return paths, nil
+}
+
+// build the prune function for the project scan from the config.  dot
+// entries like .git are always skipped, at any depth.  The returned key
+// changes whenever the pruning would, so it can invalidate the cache.
+func getScanPrune(cfg *TomlConfig) (pruneFunc, string, error) {
+	skipDirs := cfg.Generate.SkipDirs
+
+	if cfg.Generate.SkipSubmodules {
+		submodulePaths, err := getSubmodulePaths(cfg.Misc.ProjectRoot)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// a submodule path always holds a slash so that it is matched
+		// from the project root
+		for _, submodulePath := range submodulePaths {
+			skipDirs = append(skipDirs, "/"+submodulePath)
+		}
+	}
+
+	prune := func(relPath string, d fs.DirEntry) bool {
+		if strings.HasPrefix(d.Name(), ".") {
+			return true
+		}
+
+		isDir := d.IsDir() || d.Type()&fs.ModeSymlink != 0
+		return isDir && matchSkipDir(skipDirs, relPath)
+	}
+
+	pruneKey := fmt.Sprintf("hidden;symlinks=%t;skip=%s",
+		cfg.Generate.FollowSymlinks, strings.Join(skipDirs, ","))
+
+	return prune, pruneKey, nil
+}
+
+// a queuedDir is a directory waiting to be walked.  When following
+// symlinks, chain holds the real path of the directory and each of its
+// ancestors, which is what detects symlink loops.
+type queuedDir struct {
+	relPath string
+	chain   []string
+}
+
+// dirQueue holds directories waiting to be walked.  It is unbounded so
+// that a worker can always queue subdirectories without blocking on the
+// other workers.
+type dirQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	dirs     []queuedDir
+	pending  int // queued plus in-progress directories
+	stopping bool
+}
+
+func newDirQueue() *dirQueue {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *dirQueue) push(dir queuedDir) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop blocks until a directory is available.  It returns false once
+// every directory has been walked or the walk was stopped.
+func (q *dirQueue) pop() (queuedDir, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.dirs) == 0 && q.pending > 0 && !q.stopping {
+		q.cond.Wait()
+	}
+
+	if q.stopping || len(q.dirs) == 0 {
+		return queuedDir{}, false
+	}
+
+	dir := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+
+	return dir, true
+}
+
+func (q *dirQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	finished := q.pending == 0
+	q.mu.Unlock()
+
+	if finished {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *dirQueue) stop() {
+	q.mu.Lock()
+	q.stopping = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// isSymlinkLoop reports whether following a symlink to target from a
+// directory with the given chain would revisit a directory, or one of
+// its ancestors, that is already being walked.
+func isSymlinkLoop(chain []string, target string) bool {
+	for _, realPath := range chain {
+		if realPath == target || strings.HasPrefix(realPath, target+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scanProject walks root with a pool of workers.  Each worker takes a
+// directory from the queue and lists it with filepath.WalkDir, queueing
+// subdirectories for the pool instead of descending into them itself.
+// The first error, or cancelling ctx, stops the walk.
+//
+// Symlinks to directories are skipped unless followSymlinks is set.
+// Symlinks to files are always listed.
+//
+// Directories found unchanged in previous, which may be nil, are not
+// read again.  The returned cache describes this scan.
+func scanProject(ctx context.Context, root string, prune pruneFunc, followSymlinks bool, previous *scanCache) (*projectScan, *scanCache, error) {
+	start := time.Now()
+
+	current := &scanCache{
+		Root: root,
+		Dirs: make(map[string]scanCacheDir),
+	}
+	reusedCount := 0
+
+	queue := newDirQueue()
+	stopAfterCancel := context.AfterFunc(ctx, queue.stop)
+	defer stopAfterCancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		scan     projectScan
+		wg       sync.WaitGroup
+	)
+
+	// record a directory's contents in the results and the new cache
+	addDir := func(relDir string, cached scanCacheDir) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, name := range cached.Files {
+			scan.Files = append(scan.Files, filepath.Join(relDir, name))
+		}
+		if cached.HasHeader {
+			scan.HeaderDirs = append(scan.HeaderDirs, relDir)
+		}
+		scan.Dirs = append(scan.Dirs, relDir)
+
+		current.Dirs[relDir] = cached
+	}
+
+	// queue a subdirectory, extending the real path chain if symlinks
+	// are being followed.  the slice expression forces a copy so that
+	// siblings never share a chain.
+	pushSubdir := func(dir queuedDir, name, linkTarget string) {
+		sub := queuedDir{relPath: filepath.Join(dir.relPath, name)}
+
+		if followSymlinks {
+			realPath := linkTarget
+			if realPath == "" {
+				realPath = filepath.Join(dir.chain[len(dir.chain)-1], name)
+			}
+			sub.chain = append(dir.chain[:len(dir.chain):len(dir.chain)], realPath)
+		}
+
+		queue.push(sub)
+	}
+
+	walkDir := func(dir queuedDir) error {
+		relDir := dir.relPath
+		absDir := filepath.Join(root, relDir)
+		info, err := os.Stat(absDir)
+		if err != nil {
+			return err
+		}
+		modTime := info.ModTime().UnixNano()
+
+		if previous != nil {
+			cached, ok := previous.Dirs[relDir]
+			if ok && cached.ModTime == modTime {
+				for _, name := range cached.Dirs {
+					pushSubdir(dir, name, cached.Links[name])
+				}
+				addDir(relDir, cached)
+
+				mu.Lock()
+				reusedCount++
+				mu.Unlock()
+
+				return nil
+			}
+		}
+
+		// like racy git, a directory modified during this scan could
+		// change again without its mtime visibly moving, so it is not
+		// trusted next time.
+		if !info.ModTime().Before(start) {
+			modTime = 0
+		}
+
+		// WalkDir does not descend into a root that is a symlink, so a
+		// directory reached through one is walked at its real path
+		walkRoot := absDir
+		if followSymlinks {
+			walkRoot = dir.chain[len(dir.chain)-1]
+		}
+
+		listing := scanCacheDir{ModTime: modTime}
+		err = filepath.WalkDir(walkRoot, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if path == walkRoot {
+				return nil
+			}
+
+			relPath := filepath.Join(relDir, d.Name())
+			if prune(relPath, d) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if d.IsDir() {
+				listing.Dirs = append(listing.Dirs, d.Name())
+				pushSubdir(dir, d.Name(), "")
+				return filepath.SkipDir
+			}
+
+			if d.Type()&fs.ModeSymlink != 0 {
+				targetInfo, err := os.Stat(path)
+				if err == nil && targetInfo.IsDir() {
+					if !followSymlinks {
+						return nil
+					}
+
+					target, err := filepath.EvalSymlinks(path)
+					if err != nil {
+						return err
+					}
+
+					if isSymlinkLoop(dir.chain, target) {
+						if *debug {
+							fmt.Printf("Not following symlink loop %s -> %s\n", relPath, target)
+						}
+						return nil
+					}
+
+					if listing.Links == nil {
+						listing.Links = make(map[string]string)
+					}
+					listing.Links[d.Name()] = target
+					listing.Dirs = append(listing.Dirs, d.Name())
+					pushSubdir(dir, d.Name(), target)
+
+					return nil
+				}
+			}
+
+			listing.Files = append(listing.Files, d.Name())
+			if filepath.Ext(d.Name()) == ".h" {
+				listing.HasHeader = true
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		addDir(relDir, listing)
+
+		return nil
+	}
+
+	rootDir := queuedDir{relPath: "."}
+	if followSymlinks {
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, nil, err
+		}
+		rootDir.chain = []string{realRoot}
+	}
+	queue.push(rootDir)
+
+	workerCount := runtime.NumCPU() * 2
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				dir, ok := queue.pop()
+				if !ok {
+					return
+				}
+
+				err := walkDir(dir)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					queue.stop()
+				}
+
+				queue.done()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	if *debug {
+		fmt.Printf("Scanned %d dirs (%d unchanged since last scan) and %d files under %s in %v\n",
+			len(scan.Dirs), reusedCount, len(scan.Files), root, time.Since(start))
+	}
+
+	return &scan, current, nil
+}
+
+func getScanCachePath(root string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	rootHash := sha1.Sum([]byte(root))
+	return filepath.Join(cacheDir, "qtcdbg", fmt.Sprintf("scan-%x.json", rootHash[:8])), nil
+}
+
+// loadScanCache returns nil if there is no usable cache for this root
+// and prune settings.
+func loadScanCache(cachePath, root, pruneKey string) *scanCache {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil
+	}
+
+	var cache scanCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil || cache.Root != root || cache.PruneKey != pruneKey {
+		if *debug {
+			fmt.Printf("Ignoring scan cache %s\n", cachePath)
+		}
+		return nil
+	}
+
+	return &cache
+}
+
+func saveScanCache(cachePath string, cache *scanCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// a unique temp file renamed into place, so concurrent launches
+	// never read half a cache or write over each other's temp file
+	return writeFileAtomic(cachePath, data)
+}
+
+// scan the project root once, for every generator that needs it.
+// Unless rescan is set, directories that have not changed since the
+// last launch are taken from the scan cache.
+func ScanConfigProject(ctx context.Context, cfg *TomlConfig, rescan bool) error {
+	root := cfg.Misc.ProjectRoot
+
+	// a change to what gets pruned invalidates the whole cache
+	prune, pruneKey, err := getScanPrune(cfg)
+	if err != nil {
+		return err
+	}
+
+	cachePath, err := getScanCachePath(root)
+	if err != nil && *debug {
+		fmt.Printf("Not caching scan: %v\n", err)
+	}
+
+	var previous *scanCache
+	if cachePath != "" && !rescan {
+		previous = loadScanCache(cachePath, root, pruneKey)
+	}
+
+	scan, current, err := scanProject(ctx, root, prune, cfg.Generate.FollowSymlinks, previous)
+	if err != nil {
+		return err
+	}
+	cfg.Misc.Scan = scan
+
+	if cachePath != "" {
+		current.PruneKey = pruneKey
+		err = saveScanCache(cachePath, current)
+		if err != nil {
+			// the cache only saves time, it is not worth failing over
+			fmt.Fprintf(os.Stderr, "Could not save scan cache: %v\n", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// serialScan is the scan of root with a plain filepath.WalkDir, which
+// the concurrent scan has to match
+func serialScan(t *testing.T, root string, prune pruneFunc) *projectScan {
+	t.Helper()
+
+	scan := &projectScan{}
+	hasHeader := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, _ := filepath.Rel(root, path)
+		if relPath != "." && prune(relPath, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			scan.Dirs = append(scan.Dirs, relPath)
+			return nil
+		}
+
+		scan.Files = append(scan.Files, relPath)
+		if filepath.Ext(path) == ".h" && !hasHeader[filepath.Dir(relPath)] {
+			hasHeader[filepath.Dir(relPath)] = true
+			scan.HeaderDirs = append(scan.HeaderDirs, filepath.Dir(relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return scan
+}
+
+// sortScan sorts a scan's lists, which are unsorted from the workers
+func sortScan(scan *projectScan) *projectScan {
+	slices.Sort(scan.Files)
+	slices.Sort(scan.HeaderDirs)
+	slices.Sort(scan.Dirs)
+	return scan
+}
+
+func getTestScanPrune(t *testing.T, root string, skipDirs []string) pruneFunc {
+	t.Helper()
+
+	var cfg TomlConfig
+	cfg.Misc.ProjectRoot = root
+	cfg.Generate.SkipDirs = skipDirs
+	cfg.Generate.SkipSubmodules = true
+
+	prune, _, err := getScanPrune(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return prune
+}
+
+func TestScanProjectMatchesSerialWalk(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"main.c",
+		"include/app.h",
+		"src/a/a.c",
+		"src/a/a.h",
+		"src/b/c/d/deep.c",
+		".git/HEAD",
+		"src/.hidden/skipped.h",
+		"src/.dotfile",
+		"node_modules/pkg/index.js",
+		"src/node_modules/pkg/index.js",
+		"third_party/lib/test/test.c",
+		"third_party/lib/lib.c",
+		"ext/sub/sub.c",
+	} {
+		writeTestFile(t, root, name, "")
+	}
+	writeTestFile(t, root, ".gitmodules", "[submodule \"sub\"]\n\tpath = ext/sub\n\turl = https://example.com/sub.git\n")
+
+	prune := getTestScanPrune(t, root, []string{"node_modules", "third_party/*/test"})
+
+	scan, _, err := scanProject(context.Background(), root, prune, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := sortScan(serialScan(t, root, prune))
+	got := sortScan(scan)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scan is\n%+v\nwant\n%+v", got, want)
+	}
+
+	for _, skipped := range []string{".git/HEAD", "src/.hidden/skipped.h", "src/.dotfile",
+		"node_modules/pkg/index.js", "src/node_modules/pkg/index.js",
+		"third_party/lib/test/test.c", "ext/sub/sub.c"} {
+		if slices.Contains(got.Files, filepath.FromSlash(skipped)) {
+			t.Errorf("%s is in the scan", skipped)
+		}
+	}
+}
+
+func TestScanProjectSymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "a/a.c", "")
+	writeTestFile(t, root, "b/b.c", "")
+
+	for link, target := range map[string]string{
+		"a/loop": "..",   // the root, an ancestor of a
+		"a/self": ".",    // a itself
+		"a/b":    "../b", // not a loop
+	} {
+		err := os.Symlink(target, filepath.Join(root, link))
+		if err != nil {
+			t.Skipf("no symlinks: %v", err)
+		}
+	}
+
+	prune := getTestScanPrune(t, root, nil)
+	scan, _, err := scanProject(context.Background(), root, prune, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := sortScan(scan)
+	want := []string{filepath.FromSlash("a/a.c"), filepath.FromSlash("a/b/b.c"), filepath.FromSlash("b/b.c")}
+	if !reflect.DeepEqual(got.Files, want) {
+		t.Errorf("files are %q, want %q", got.Files, want)
+	}
+}
+
+func TestScanProjectCancel(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "a/a.c", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := scanProject(ctx, root, getTestScanPrune(t, root, nil), false, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err is %v, want %v", err, context.Canceled)
+	}
+}
+
+// a second scan takes unchanged directories from the cache, and reads
+// again the ones a file was added to
+func TestScanProjectCache(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, root, "a/a.c", "")
+	writeTestFile(t, root, "a/b/b.c", "")
+	writeTestFile(t, root, "c/c.c", "")
+
+	// directories changed during a scan aren't cached, so these are
+	// made older than the first one
+	old := time.Now().Add(-time.Hour)
+	for _, dir := range []string{".", "a", "a/b", "c"} {
+		err := os.Chtimes(filepath.Join(root, dir), old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prune := getTestScanPrune(t, root, nil)
+	_, cache, err := scanProject(context.Background(), root, prune, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a name only the cache has shows that c is not read again
+	cached := cache.Dirs["c"]
+	cached.Files = append(cached.Files, "cached-only.c")
+	cache.Dirs["c"] = cached
+
+	writeTestFile(t, root, "a/b/new.c", "")
+
+	scan, _, err := scanProject(context.Background(), root, prune, false, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := sortScan(scan).Files
+	for _, name := range []string{"a/b/new.c", "c/cached-only.c"} {
+		if !slices.Contains(got, filepath.FromSlash(name)) {
+			t.Errorf("%s is not in the second scan: %q", name, got)
+		}
+	}
+}
+
+// the cache is only used for the root and pruning it was saved with
+func TestLoadScanCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "qtcdbg", "scan.json")
+	cache := &scanCache{
+		Root:     "/project",
+		PruneKey: "key",
+		Dirs:     map[string]scanCacheDir{".": {ModTime: 1, Files: []string{"a.c"}}},
+	}
+
+	err := saveScanCache(cachePath, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := loadScanCache(cachePath, "/project", "key")
+	if !reflect.DeepEqual(loaded, cache) {
+		t.Errorf("loaded %+v, want %+v", loaded, cache)
+	}
+
+	if loadScanCache(cachePath, "/other", "key") != nil {
+		t.Errorf("the cache was loaded for another root")
+	}
+	if loadScanCache(cachePath, "/project", "other") != nil {
+		t.Errorf("the cache was loaded for other prune settings")
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// getJsonSchema describes a TomlConfig field type as a JSON Schema,
+// following the toml tags so it matches what the decoder accepts
+func getJsonSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(commandArgs{}) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": getJsonSchema(t.Elem())}
+
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": getJsonSchema(t.Elem())}
+
+	case reflect.Pointer:
+		return getJsonSchema(t.Elem())
+
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			if name == "" || name == "-" || !field.IsExported() {
+				continue
+			}
+
+			properties[name] = getJsonSchema(field.Type)
+		}
+
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// getConfigSchema is the JSON Schema for a whole config file
+func getConfigSchema() map[string]interface{} {
+	schema := getJsonSchema(reflect.TypeOf(TomlConfig{}))
+	schema["$schema"] = "https://json-schema.org/draft-07/schema#"
+	schema["title"] = "qtcdbg config"
+
+	// a JSON config may name its schema for editors to find
+	properties := schema["properties"].(map[string]interface{})
+	properties["$schema"] = map[string]interface{}{"type": "string"}
+
+	return schema
+}
+
+// Schema prints the JSON Schema of the config file.  It validates JSON
+// configs, and TOML configs in editors that apply JSON Schemas to TOML.
+func Schema() int {
+	schema, err := json.MarshalIndent(getConfigSchema(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Println(string(schema))
+
+	return 0
+}
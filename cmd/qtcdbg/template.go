@@ -12,7 +12,7 @@ var tmplCreator_411_0 = `<?xml version="1.0" encoding="UTF-8"?>
 <qtcreator>
  <data>
   <variable>EnvironmentId</variable>
-  <value type="QByteArray">{{ printf "{%s}" .Misc.EnvironmentId}}</value>
+  <value type="QByteArray">{{ printf "{%s}" .Misc.EnvironmentId | xml }}</value>
  </data>
  <data>
   <variable>ProjectExplorer.Project.ActiveTarget</variable>
@@ -89,18 +89,18 @@ var tmplCreator_411_0 = `<?xml version="1.0" encoding="UTF-8"?>
   <valuemap type="QVariantMap">
    <value type="QString" key="ProjectExplorer.ProjectConfiguration.DefaultDisplayName">Desktop</value>
    <value type="QString" key="ProjectExplorer.ProjectConfiguration.DisplayName">Desktop</value>
-   <value type="QString" key="ProjectExplorer.ProjectConfiguration.Id">{{ printf "{%s}" .Misc.KitId }}</value>
+   <value type="QString" key="ProjectExplorer.ProjectConfiguration.Id">{{ printf "{%s}" .Misc.KitId | xml }}</value>
    <value type="int" key="ProjectExplorer.Target.ActiveBuildConfiguration">0</value>
    <value type="int" key="ProjectExplorer.Target.ActiveDeployConfiguration">0</value>
    <value type="int" key="ProjectExplorer.Target.ActiveRunConfiguration">0</value>
    <valuemap type="QVariantMap" key="ProjectExplorer.Target.BuildConfiguration.0">
-    <value type="QString" key="ProjectExplorer.BuildConfiguration.BuildDirectory">{{.Misc.ProjectRoot}}</value>
+    <value type="QString" key="ProjectExplorer.BuildConfiguration.BuildDirectory">{{ xml .Misc.ProjectRoot }}</value>
     <valuemap type="QVariantMap" key="ProjectExplorer.BuildConfiguration.BuildStepList.0">
      <valuemap type="QVariantMap" key="ProjectExplorer.BuildStepList.Step.0">
       <value type="bool" key="ProjectExplorer.BuildStep.Enabled">true</value>
-      <value type="QString" key="ProjectExplorer.ProcessStep.Arguments">{{.Build.Arguments}}</value>
-      <value type="QString" key="ProjectExplorer.ProcessStep.Command">{{.Build.Command}}</value>
-      <value type="QString" key="ProjectExplorer.ProcessStep.WorkingDirectory">{{.Build.WorkingDir}}</value>
+      <value type="QString" key="ProjectExplorer.ProcessStep.Arguments">{{ xml .Build.Arguments }}</value>
+      <value type="QString" key="ProjectExplorer.ProcessStep.Command">{{ xml .Build.Command }}</value>
+      <value type="QString" key="ProjectExplorer.ProcessStep.WorkingDirectory">{{ xml .Build.WorkingDir }}</value>
       <value type="QString" key="ProjectExplorer.ProjectConfiguration.Id">ProjectExplorer.ProcessStep</value>
      </valuemap>
      <value type="int" key="ProjectExplorer.BuildStepList.StepsCount">1</value>
@@ -133,7 +133,8 @@ var tmplCreator_411_0 = `<?xml version="1.0" encoding="UTF-8"?>
    </valuemap>
    <value type="int" key="ProjectExplorer.Target.DeployConfigurationCount">1</value>
    <valuemap type="QVariantMap" key="ProjectExplorer.Target.PluginSettings"/>
-   <valuemap type="QVariantMap" key="ProjectExplorer.Target.RunConfiguration.0">
+   {{- range $i, $run := .Misc.RunConfigs }}
+   <valuemap type="QVariantMap" key="ProjectExplorer.Target.RunConfiguration.{{ $i }}">
     <value type="QString" key="Analyzer.Perf.CallgraphMode">dwarf</value>
     <valuelist type="QVariantList" key="Analyzer.Perf.Events">
      <value type="QString">cpu-cycles</value>
@@ -192,23 +193,31 @@ var tmplCreator_411_0 = `<?xml version="1.0" encoding="UTF-8"?>
      <value type="int">14</value>
     </valuelist>
     <value type="int" key="PE.EnvironmentAspect.Base">2</value>
-    <valuelist type="QVariantList" key="PE.EnvironmentAspect.Changes"/>
-    <value type="QString" key="ProjectExplorer.CustomExecutableRunConfiguration.Executable">{{.Run.ExecutablePath}}</value>
+    <valuelist type="QVariantList" key="PE.EnvironmentAspect.Changes">
+     {{- range $name, $value := $run.Environment }}
+     <value type="QString">{{ printf "%s=%s" $name $value | xml }}</value>
+     {{- end }}
+    </valuelist>
+    <value type="QString" key="ProjectExplorer.CustomExecutableRunConfiguration.Executable">{{ xml $run.ExecutablePath }}</value>
+    {{- if $run.Name }}
+    <value type="QString" key="ProjectExplorer.ProjectConfiguration.DisplayName">{{ xml $run.Name }}</value>
+    {{- end }}
     <value type="QString" key="ProjectExplorer.ProjectConfiguration.Id">ProjectExplorer.CustomExecutableRunConfiguration</value>
     <value type="QString" key="ProjectExplorer.RunConfiguration.BuildKey"></value>
-    <value type="QString" key="RunConfiguration.Arguments">{{.Run.Arguments}}</value>
+    <value type="QString" key="RunConfiguration.Arguments">{{ xml $run.Arguments }}</value>
     <value type="bool" key="RunConfiguration.Arguments.multi">false</value>
-    <value type="QString" key="RunConfiguration.OverrideDebuggerStartup"></value>
+    <value type="QString" key="RunConfiguration.OverrideDebuggerStartup">{{ join $run.DebuggerStartup "\n" | xml }}</value>
     <value type="bool" key="RunConfiguration.UseCppDebugger">false</value>
     <value type="bool" key="RunConfiguration.UseCppDebuggerAuto">true</value>
     <value type="bool" key="RunConfiguration.UseMultiProcess">false</value>
     <value type="bool" key="RunConfiguration.UseQmlDebugger">false</value>
     <value type="bool" key="RunConfiguration.UseQmlDebuggerAuto">true</value>
-    <value type="bool" key="RunConfiguration.UseTerminal">{{ printf "%t" .Run.RunInTerminal }}</value>
-    <value type="QString" key="RunConfiguration.WorkingDirectory">{{.Run.WorkingDir}}</value>
+    <value type="bool" key="RunConfiguration.UseTerminal">{{ printf "%t" $run.RunInTerminal }}</value>
+    <value type="QString" key="RunConfiguration.WorkingDirectory">{{ xml $run.WorkingDir }}</value>
     <value type="QString" key="RunConfiguration.WorkingDirectory.default"></value>
    </valuemap>
-   <value type="int" key="ProjectExplorer.Target.RunConfigurationCount">1</value>
+   {{- end }}
+   <value type="int" key="ProjectExplorer.Target.RunConfigurationCount">{{ len .Misc.RunConfigs }}</value>
   </valuemap>
  </data>
  <data>
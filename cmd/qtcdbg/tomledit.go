@@ -0,0 +1,323 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	reTableHeader = regexp.MustCompile(`^\s*\[\[?\s*([^\]]+?)\s*\]\]?`)
+	reKeyValue    = regexp.MustCompile(`^(\s*)("[^"]*"|'[^']*'|[A-Za-z0-9_.-]+)(\s*=\s*)`)
+)
+
+// tomlDocument is a TOML file edited line by line so that comments and
+// formatting survive the edit.  It only understands the subset of TOML
+// that qtcdbg configs use: [table] headers and key = value lines, where
+// an array value may span several lines.
+type tomlDocument struct {
+	lines []string
+
+	// the table the config is in, like tool.qtcdbg in a pyproject.toml.
+	// The tables findKey, renameKey and setKey are given are in it.
+	prefix string
+}
+
+func parseTomlDocument(data []byte) *tomlDocument {
+	text := strings.TrimSuffix(string(data), "\n")
+	return &tomlDocument{lines: strings.Split(text, "\n")}
+}
+
+func (doc *tomlDocument) Bytes() []byte {
+	return []byte(strings.Join(doc.lines, "\n") + "\n")
+}
+
+// getLineTable returns the table name if line is a table header
+func getLineTable(line string) (string, bool) {
+	match := reTableHeader.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+
+	return strings.ReplaceAll(match[1], " ", ""), true
+}
+
+// getLineKey returns the bare key if line is a key = value line
+func getLineKey(line string) (string, bool) {
+	match := reKeyValue.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+
+	return strings.Trim(match[2], `"'`), true
+}
+
+// valueLineCount is how many lines the value starting on line i spans,
+// counting the brackets of a multi-line array
+func (doc *tomlDocument) valueLineCount(i int) int {
+	depth := 0
+	for n := i; n < len(doc.lines); n++ {
+		line := doc.lines[n]
+		if n == i {
+			line = reKeyValue.ReplaceAllString(line, "")
+		}
+
+		inString := byte(0)
+		for c := 0; c < len(line); c++ {
+			switch ch := line[c]; {
+			case inString != 0:
+				if ch == '\\' && inString == '"' {
+					c++
+				} else if ch == inString {
+					inString = 0
+				}
+			case ch == '"' || ch == '\'':
+				inString = ch
+			case ch == '#':
+				c = len(line)
+			case ch == '[' || ch == '{':
+				depth++
+			case ch == ']' || ch == '}':
+				depth--
+			}
+		}
+
+		if depth <= 0 {
+			return n - i + 1
+		}
+	}
+
+	return len(doc.lines) - i
+}
+
+// getTableName is the full name of a table in the config
+func (doc *tomlDocument) getTableName(table string) string {
+	switch {
+	case doc.prefix == "":
+		return table
+	case table == "":
+		return doc.prefix
+	}
+
+	return doc.prefix + "." + table
+}
+
+// findKey returns the index of the line defining key in table, or -1.
+// The top level table is "".  Dotted keys at the top level, as in
+// 'compile_commands.dir = "build"', are found too.
+func (doc *tomlDocument) findKey(table, key string) int {
+	table = doc.getTableName(table)
+
+	currentTable := ""
+	for i, line := range doc.lines {
+		if lineTable, ok := getLineTable(line); ok {
+			currentTable = lineTable
+			continue
+		}
+
+		lineKey, ok := getLineKey(line)
+		if !ok {
+			continue
+		}
+
+		if currentTable == table && lineKey == key {
+			return i
+		}
+
+		if currentTable == "" && table != "" && lineKey == table+"."+key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// findTable returns the index of the header line of a table, by its
+// full name, or -1
+func (doc *tomlDocument) findTable(table string) int {
+	for i, line := range doc.lines {
+		if lineTable, ok := getLineTable(line); ok && lineTable == table {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// renameKey renames a key in place, keeping its value and any trailing
+// comment.  It returns false if the key is not in the document.
+func (doc *tomlDocument) renameKey(table, from, to string) bool {
+	i := doc.findKey(table, from)
+	if i == -1 {
+		return false
+	}
+
+	match := reKeyValue.FindStringSubmatch(doc.lines[i])
+	newKey := to
+	if strings.Contains(match[2], ".") {
+		newKey = strings.TrimSuffix(match[2], from) + to
+	}
+	doc.lines[i] = match[1] + newKey + match[3] + doc.lines[i][len(match[0]):]
+
+	return true
+}
+
+// setKey sets key in table to value, which must already be TOML
+// encoded.  An existing value is replaced, keeping the indentation and
+// trailing comment of a single line value.  A new key goes at the end
+// of its table, and a missing table is appended to the document.
+func (doc *tomlDocument) setKey(table, key, value string) {
+	if i := doc.findKey(table, key); i != -1 {
+		match := reKeyValue.FindStringSubmatch(doc.lines[i])
+
+		comment := ""
+		count := doc.valueLineCount(i)
+		if count == 1 {
+			comment = getTrailingComment(doc.lines[i][len(match[0]):])
+		}
+
+		newLine := match[1] + match[2] + match[3] + value + comment
+		doc.lines = append(doc.lines[:i], append([]string{newLine}, doc.lines[i+count:]...)...)
+		return
+	}
+
+	newLine := key + " = " + value
+
+	table = doc.getTableName(table)
+	if table == "" {
+		// top level keys must come before the first table
+		insertAt := 0
+		for i, line := range doc.lines {
+			if _, ok := getLineTable(line); ok {
+				insertAt = i
+				break
+			}
+			insertAt = i + 1
+		}
+		doc.insertLines(insertAt, newLine, "")
+		return
+	}
+
+	header := doc.findTable(table)
+	if header == -1 {
+		// ahead of its sub-tables, if it has any
+		for i, line := range doc.lines {
+			if lineTable, ok := getLineTable(line); ok && strings.HasPrefix(lineTable, table+".") {
+				doc.insertLines(i, "["+table+"]", newLine, "")
+				return
+			}
+		}
+
+		doc.lines = append(doc.lines, "", "["+table+"]", newLine)
+		return
+	}
+
+	// after the last key in the table, before the blank lines and
+	// comments that lead into the next table
+	insertAt := header + 1
+	for i := header + 1; i < len(doc.lines); i++ {
+		if _, ok := getLineTable(doc.lines[i]); ok {
+			break
+		}
+		if _, ok := getLineKey(doc.lines[i]); ok {
+			i += doc.valueLineCount(i) - 1
+			insertAt = i + 1
+		}
+	}
+	doc.insertLines(insertAt, newLine)
+}
+
+// getString returns the value of key in table if it is a string on one
+// line
+func (doc *tomlDocument) getString(table, key string) (string, bool) {
+	i := doc.findKey(table, key)
+	if i == -1 || doc.valueLineCount(i) != 1 {
+		return "", false
+	}
+
+	match := reKeyValue.FindStringSubmatch(doc.lines[i])
+
+	var decoded struct{ V interface{} }
+	_, err := toml.Decode("v = "+doc.lines[i][len(match[0]):], &decoded)
+	if err != nil {
+		return "", false
+	}
+
+	value, ok := decoded.V.(string)
+	return value, ok
+}
+
+// setString sets key in table to a string value
+func (doc *tomlDocument) setString(table, key, value string) error {
+	encoded, err := encodeTomlValue(value)
+	if err != nil {
+		return err
+	}
+
+	doc.setKey(table, key, encoded)
+	return nil
+}
+
+func (doc *tomlDocument) insertLines(at int, lines ...string) {
+	doc.lines = append(doc.lines[:at], append(lines, doc.lines[at:]...)...)
+}
+
+// getTrailingComment returns the '  # comment' after a single line
+// value, including the whitespace before it, or ""
+func getTrailingComment(value string) string {
+	inString := byte(0)
+	for c := 0; c < len(value); c++ {
+		switch ch := value[c]; {
+		case inString != 0:
+			if ch == '\\' && inString == '"' {
+				c++
+			} else if ch == inString {
+				inString = 0
+			}
+		case ch == '"' || ch == '\'':
+			inString = ch
+		case ch == '#':
+			start := c
+			for start > 0 && (value[start-1] == ' ' || value[start-1] == '\t') {
+				start--
+			}
+			return value[start:]
+		}
+	}
+
+	return ""
+}
+
+// encodeTomlValue encodes a single value as it would appear after the
+// equals sign of a key = value line
+func encodeTomlValue(value interface{}) (string, error) {
+	var encoded strings.Builder
+	err := toml.NewEncoder(&encoded).Encode(map[string]interface{}{"v": value})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(encoded.String(), "v = "), "\n"), nil
+}
+
+// prefixTables puts every table header in data inside table, so that
+// TOML encoded on its own can be added to a document with a prefix
+func prefixTables(data []byte, table string) []byte {
+	if table == "" {
+		return data
+	}
+
+	doc := parseTomlDocument(data)
+	for i, line := range doc.lines {
+		if name, ok := getLineTable(line); ok {
+			doc.lines[i] = strings.Replace(line, name, table+"."+name, 1)
+		}
+	}
+
+	return doc.Bytes()
+}
@@ -0,0 +1,427 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// matches a ${name} variable in a VS Code launch.json value
+var reVscodeVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// launch.json keys that are translated, or that QtCreator has no use
+// for.  Any other key in a launch entry is reported as ignored.
+var vscodeLaunchKeys = map[string]bool{
+	"name":            true,
+	"type":            true,
+	"request":         true,
+	"program":         true,
+	"args":            true,
+	"cwd":             true,
+	"environment":     true,
+	"setupCommands":   true,
+	"externalConsole": true,
+	"MIMode":          true,
+	"presentation":    true,
+	"linux":           true,
+	"osx":             true,
+	"windows":         true,
+}
+
+// stripJsonComments blanks out the // and /* */ comments and trailing
+// commas VS Code allows in its JSON files, so encoding/json can read
+// them.  Newlines are kept, so offsets still point at the same lines.
+func stripJsonComments(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	blank := func(from, to int) {
+		for i := from; i < to; i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+
+	inString := false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			end := bytes.IndexByte(out[i:], '\n')
+			if end == -1 {
+				end = len(out) - i
+			}
+			blank(i, i+end)
+			i += end
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := bytes.Index(out[i+2:], []byte("*/"))
+			if end == -1 {
+				end = len(out) - i - 2
+			} else {
+				end += 2
+			}
+			blank(i, i+2+end)
+			i += 1 + end
+		}
+	}
+
+	// with the comments gone, a trailing comma is one followed only by
+	// space before the end of its object or array
+	inString = false
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+
+		switch {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case !inString && c == ',':
+			next := bytes.TrimLeft(out[i+1:], " \t\r\n")
+			if len(next) != 0 && (next[0] == '}' || next[0] == ']') {
+				out[i] = ' '
+			}
+		}
+	}
+
+	return out
+}
+
+// translateVscodeValue rewrites the VS Code variables in s as qtcdbg
+// config variables, escaping any other dollar signs
+func translateVscodeValue(s, projectRoot string) (string, error) {
+	var translated strings.Builder
+	var err error
+
+	last := 0
+	for _, match := range reVscodeVariable.FindAllStringSubmatchIndex(s, -1) {
+		translated.WriteString(strings.ReplaceAll(s[last:match[0]], "$", "$$"))
+		last = match[1]
+
+		name := s[match[2]:match[3]]
+		switch {
+		case name == "workspaceFolder" || name == "workspaceRoot":
+			translated.WriteString("${project_root}")
+		case name == "workspaceFolderBasename":
+			translated.WriteString(strings.ReplaceAll(filepath.Base(projectRoot), "$", "$$"))
+		case name == "userHome":
+			translated.WriteString("${env:HOME}")
+		case name == "pathSeparator":
+			translated.WriteString(string(filepath.Separator))
+		case strings.HasPrefix(name, "env:"):
+			translated.WriteString("${" + name + "}")
+		default:
+			if err == nil {
+				err = fmt.Errorf("can't translate ${%s}", name)
+			}
+		}
+	}
+	translated.WriteString(strings.ReplaceAll(s[last:], "$", "$$"))
+
+	return translated.String(), err
+}
+
+// translateVscodePath is translateVscodeValue for a path.  Paths in the
+// workspace folder are made relative, since relative paths in the
+// config are relative to the project root.
+func translateVscodePath(s, projectRoot string) (string, error) {
+	translated, err := translateVscodeValue(s, projectRoot)
+	if err != nil {
+		return "", err
+	}
+
+	if translated == "${project_root}" {
+		return "./", nil
+	}
+
+	if rel, ok := strings.CutPrefix(translated, "${project_root}/"); ok {
+		return rel, nil
+	}
+
+	return translated, nil
+}
+
+// the launch.json key that holds settings for this platform
+func getVscodePlatformKey() string {
+	if runtime.GOOS == "darwin" {
+		return "osx"
+	}
+
+	return runtime.GOOS
+}
+
+// translateVscodeLaunch converts one launch.json configuration to a run
+// config.  ignored lists the keys in it that were not translated.  An
+// error means the entry can't be run from QtCreator as it is.
+func translateVscodeLaunch(entry map[string]interface{}, projectRoot string) (run runConfig, ignored []string, err error) {
+	// the settings for this platform override the entry's own
+	if platform, ok := entry[getVscodePlatformKey()].(map[string]interface{}); ok {
+		merged := make(map[string]interface{})
+		for key, value := range entry {
+			merged[key] = value
+		}
+		for key, value := range platform {
+			merged[key] = value
+		}
+		entry = merged
+	}
+
+	for key := range entry {
+		if !vscodeLaunchKeys[key] {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+
+	if entryType, _ := entry["type"].(string); entryType != "cppdbg" {
+		return run, ignored, fmt.Errorf("type is \"%s\", only cppdbg entries are imported", entryType)
+	}
+
+	if request, _ := entry["request"].(string); request != "launch" {
+		return run, ignored, fmt.Errorf("request is \"%s\", QtCreator can only launch", request)
+	}
+
+	run.Name, _ = entry["name"].(string)
+
+	program, ok := entry["program"].(string)
+	if !ok || program == "" {
+		return run, ignored, fmt.Errorf("it has no program")
+	}
+
+	run.ExecutablePath, err = translateVscodePath(program, projectRoot)
+	if err != nil {
+		return run, ignored, fmt.Errorf("program: %v", err)
+	}
+
+	run.WorkingDir = "./"
+	if cwd, ok := entry["cwd"].(string); ok {
+		run.WorkingDir, err = translateVscodePath(cwd, projectRoot)
+		if err != nil {
+			return run, ignored, fmt.Errorf("cwd: %v", err)
+		}
+	}
+
+	run.Arguments = commandArgs{list: []string{}, isList: true}
+	args, _ := entry["args"].([]interface{})
+	for _, arg := range args {
+		arg, ok := arg.(string)
+		if !ok {
+			return run, ignored, fmt.Errorf("args: only strings are supported")
+		}
+
+		arg, err = translateVscodeValue(arg, projectRoot)
+		if err != nil {
+			return run, ignored, fmt.Errorf("args: %v", err)
+		}
+		run.Arguments.list = append(run.Arguments.list, arg)
+	}
+
+	environment, _ := entry["environment"].([]interface{})
+	for _, variable := range environment {
+		variable, _ := variable.(map[string]interface{})
+		name, _ := variable["name"].(string)
+		value, _ := variable["value"].(string)
+		if name == "" {
+			return run, ignored, fmt.Errorf("environment: every variable needs a name")
+		}
+
+		value, err = translateVscodeValue(value, projectRoot)
+		if err != nil {
+			return run, ignored, fmt.Errorf("environment: %s: %v", name, err)
+		}
+
+		if run.Environment == nil {
+			run.Environment = make(map[string]string)
+		}
+		run.Environment[name] = value
+	}
+
+	// setup commands are usually gdb/mi commands, while QtCreator runs
+	// its startup commands in the gdb console.  -gdb-set has a console
+	// equivalent; QtCreator already pretty prints.
+	setupCommands, _ := entry["setupCommands"].([]interface{})
+	for _, command := range setupCommands {
+		command, _ := command.(map[string]interface{})
+		text, _ := command["text"].(string)
+		text = strings.TrimSpace(text)
+
+		switch {
+		case text == "" || text == "-enable-pretty-printing":
+		case strings.HasPrefix(text, "-gdb-set "):
+			run.DebuggerStartup = append(run.DebuggerStartup, "set "+strings.TrimPrefix(text, "-gdb-set "))
+		case strings.HasPrefix(text, "-"):
+			ignored = append(ignored, fmt.Sprintf("setupCommands \"%s\"", text))
+		default:
+			run.DebuggerStartup = append(run.DebuggerStartup, text)
+		}
+	}
+
+	run.RunInTerminal, _ = entry["externalConsole"].(bool)
+
+	return run, ignored, nil
+}
+
+// getVscodeLaunchPath finds launch.json from the path given on the
+// command line, which may be the file, the .vscode folder or the
+// workspace folder.  With no path, it is in the project root.
+func getVscodeLaunchPath(path, projectRoot string) string {
+	if path == "" {
+		path = projectRoot
+	}
+
+	for _, candidate := range []string{
+		filepath.Join(path, ".vscode", "launch.json"),
+		filepath.Join(path, "launch.json"),
+	} {
+		if isFile(candidate) {
+			return candidate
+		}
+	}
+
+	return path
+}
+
+// ImportVscode adds a [[runs]] table to the config for each cppdbg
+// launch configuration in a VS Code launch.json.  Configurations with
+// the name of a run already in the config are skipped, so importing
+// again only adds new ones.  Anything that could not be translated is
+// reported.
+func ImportVscode() int {
+	configPath, err := findConfig("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not find config: %v\n", err)
+		return 1
+	}
+
+	if isJsonConfig(configPath) {
+		fmt.Fprintf(os.Stderr, "%s: import only edits TOML configs\n", configPath)
+		return 1
+	}
+
+	original, err := os.ReadFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	cfg, err := loadConfigLayers(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	projectRoot := getProjectRoot(&cfg)
+
+	launchPath := getVscodeLaunchPath(*importVscodePath, projectRoot)
+	data, err := os.ReadFile(launchPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read launch.json: %v\n", err)
+		return 1
+	}
+
+	var launch struct {
+		Configurations []map[string]interface{} `json:"configurations"`
+	}
+	err = json.Unmarshal(stripJsonComments(data), &launch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", launchPath, err)
+		return 1
+	}
+
+	existing := map[string]bool{cfg.Run.Name: true}
+	for _, run := range cfg.Runs {
+		existing[run.Name] = true
+	}
+
+	var imported []runConfig
+	failed := 0
+	for i, entry := range launch.Configurations {
+		name, _ := entry["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("configuration %d", i+1)
+			entry["name"] = name
+		}
+
+		if existing[name] {
+			fmt.Printf("Skipping \"%s\", which is already in %s\n", name, configPath)
+			continue
+		}
+
+		run, ignored, err := translateVscodeLaunch(entry, projectRoot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: could not import \"%s\": %v\n", launchPath, name, err)
+			failed++
+			continue
+		}
+
+		if len(ignored) != 0 {
+			fmt.Fprintf(os.Stderr, "%s: \"%s\": ignored %s\n", launchPath, name, strings.Join(ignored, ", "))
+		}
+
+		existing[name] = true
+		imported = append(imported, run)
+	}
+
+	if len(imported) == 0 {
+		fmt.Printf("Nothing to import from %s\n", launchPath)
+		if failed != 0 {
+			return 1
+		}
+		return 0
+	}
+
+	var runs bytes.Buffer
+	encoder := toml.NewEncoder(&runs)
+	encoder.Indent = ""
+	err = encoder.Encode(struct {
+		Runs []runConfig `toml:"runs"`
+	}{imported})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// the runs are added at the end, leaving the rest of the file as is
+	updated := bytes.TrimRight(original, "\n")
+	source := launchPath
+	configDir, err := filepath.Abs(filepath.Dir(configPath))
+	if err == nil {
+		if rel, err := filepath.Rel(configDir, launchPath); err == nil {
+			source = rel
+		}
+	}
+	updated = append(updated, fmt.Sprintf("\n\n# imported from %s\n", filepath.ToSlash(source))...)
+	encoded := runs.Bytes()
+	if isPyprojectConfig(configPath) {
+		encoded = prefixTables(encoded, pyprojectTable)
+	}
+	updated = append(updated, encoded...)
+
+	err = writeFileAtomic(configPath, updated)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write config: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Imported %d run configurations from %s into %s\n", len(imported), launchPath, configPath)
+
+	return 0
+}
@@ -0,0 +1,163 @@
+/*
+ * qtcdbg Copyright (C) 2019-2020, 2024 Frogtoss Games, Inc.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// how long the tree has to be quiet before regenerating, so that a
+// checkout or build touching many files triggers only one regeneration
+const watchDebounce = 500 * time.Millisecond
+
+// the generated files that list the project tree, which are the only
+// ones that can change while QtCreator runs
+var watchedGenerators = []string{".files", ".includes"}
+
+// watch every scanned directory that is not watched yet.  inotify does
+// not watch recursively, so each directory needs its own watch.
+func addProjectWatches(watcher *fsnotify.Watcher, cfg *TomlConfig, watched map[string]bool) {
+	for _, relDir := range cfg.Misc.Scan.Dirs {
+		absDir := filepath.Join(cfg.Misc.ProjectRoot, relDir)
+		if watched[absDir] {
+			continue
+		}
+
+		err := watcher.Add(absDir)
+		if err != nil {
+			// most likely out of inotify watches; the rest of the
+			// tree is still watched
+			fmt.Fprintf(os.Stderr, "Could not watch %s: %v\n", absDir, err)
+			continue
+		}
+		watched[absDir] = true
+	}
+}
+
+// rescan the project and rewrite the file listings if they changed
+func regenerateListings(ctx context.Context, cfg *TomlConfig) error {
+	err := ScanConfigProject(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+
+	for _, gen := range generatedFiles() {
+		if !slices.Contains(watchedGenerators, gen.suffix) {
+			continue
+		}
+
+		body, err := gen.render(cfg)
+		if err != nil {
+			return err
+		}
+
+		path := getGeneratorPath(cfg, cfg.Project.Name+gen.suffix)
+		existing, err := os.ReadFile(path)
+		if err == nil && string(existing) == body {
+			continue
+		}
+
+		err = writeFileAtomic(path, []byte(body))
+		if err != nil {
+			return err
+		}
+
+		if *debug {
+			fmt.Printf("Regenerated %s\n", path)
+		}
+	}
+
+	return nil
+}
+
+// WatchProject keeps .files and .includes up to date with the project
+// tree until ctx is cancelled.  The files are replaced atomically, so
+// QtCreator's generic project manager sees a complete file when it
+// reloads them.
+func WatchProject(ctx context.Context, cfg *TomlConfig) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	watched := make(map[string]bool)
+	addProjectWatches(watcher, cfg, watched)
+
+	if *debug {
+		fmt.Printf("Watching %d dirs for changes\n", len(watched))
+	}
+
+	// writing the generated files must not set off another round if
+	// they live under the project root.  Only they and their temp files
+	// are skipped, since the output dir can be the project root itself.
+	generated := make(map[string]bool)
+	for _, gen := range generatedFiles() {
+		generated[getGeneratorPath(cfg, cfg.Project.Name+gen.suffix)] = true
+	}
+
+	isGenerated := func(path string) bool {
+		if generated[path] {
+			return true
+		}
+
+		isTemp, _ := filepath.Match(".qtcdbg-*.tmp", filepath.Base(path))
+		return isTemp && filepath.Dir(path) == cfg.Misc.OutputDir
+	}
+
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// only entries coming and going change the listings
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+
+			if isGenerated(event.Name) {
+				continue
+			}
+
+			// inotify drops the watch on a removed directory, so it has
+			// to be added again if the directory comes back
+			if !event.Has(fsnotify.Create) {
+				delete(watched, event.Name)
+			}
+
+			debounce.Reset(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+
+		case <-debounce.C:
+			err := regenerateListings(ctx, cfg)
+			if err != nil {
+				handleGenerationError(err)
+				continue
+			}
+
+			addProjectWatches(watcher, cfg, watched)
+		}
+	}
+}
@@ -6,12 +6,13 @@ require (
 	github.com/BurntSushi/toml v1.3.2
 	github.com/alecthomas/kingpin v2.2.6+incompatible
 	github.com/chzyer/readline v1.5.1
+	github.com/fsnotify/fsnotify v1.7.0
+	gopkg.in/ini.v1 v1.67.0
 )
 
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20231202071711-9a357b53e9c9 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
-	golang.org/x/sys v0.0.0-20220310020820-b874c991c1a5 // indirect
-	gopkg.in/ini.v1 v1.67.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )